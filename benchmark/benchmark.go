@@ -15,6 +15,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+	"usermana/config"
 )
 
 func benchmarkBasicN(serverAddr string, n, c int32, isRan bool, ishttpPostMethod bool) (elapsed time.Duration) {
@@ -74,7 +75,7 @@ func benchmarkBasicN(serverAddr string, n, c int32, isRan bool, ishttpPostMethod
 			}
 			//设置http请求的cookie
 			req.AddCookie(&http.Cookie{Name: "username", Value: username, Expires: time.Now().Add(120 * time.Second)})
-			req.AddCookie(&http.Cookie{Name: "token", Value: "test", Expires: time.Now().Add(120 * time.Second)})
+			req.AddCookie(&http.Cookie{Name: "token", Value: config.BenchmarkToken, Expires: time.Now().Add(120 * time.Second)})
 
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded; param=value") // This makes it work
 			if err != nil {
@@ -111,6 +112,7 @@ func benchmarkBasicN(serverAddr string, n, c int32, isRan bool, ishttpPostMethod
 	return time.Since(start)
 }
 
+var serverAddr string
 var num int64
 var concurrency int64
 var isRandom bool
@@ -118,6 +120,8 @@ var ishttpPostMethod bool
 
 //init 初始化命令行参数默认值.
 func init() {
+	//压测的接口.
+	flag.StringVar(&serverAddr, "u", "http://127.0.0.1:1088/login", "url")
 	//用户数量.
 	flag.Int64Var(&num, "n", 10000, "num")
 	//并发数量.
@@ -131,8 +135,17 @@ func init() {
 func main() {
 	//解析命令行参数.
 	flag.Parse()
+	//除了注册和登录, 其他接口都需要token. 压测用户没有登录, 使用服务端配置的压测token,
+	//未配置时所有请求都会鉴权失败, 测到的只是拒绝请求的耗时.
+	u, err := url.Parse(serverAddr)
+	if err != nil {
+		log.Fatalf("invalid url %q: %v", serverAddr, err)
+	}
+	if u.Path != "/login" && u.Path != "/signUp" && config.BenchmarkToken == "" {
+		log.Fatalf("%s requires a token: set config.BenchmarkToken (and restart the tcp server) before benchmarking it", u.Path)
+	}
 	//进行模拟测试.
-	elapsed := benchmarkBasicN("http://127.0.0.1:1088/login", int32(num), int32(concurrency), isRandom, ishttpPostMethod)
+	elapsed := benchmarkBasicN(serverAddr, int32(num), int32(concurrency), isRandom, ishttpPostMethod)
 	fmt.Println("HTTP server benchmark done:")
 	fmt.Printf("\tTotal Requests(%v) - Concurrency(%v) - Random(%t) - Cost(%s) - QPS(%v/sec)\n",
 		num, concurrency, isRandom, elapsed, math.Ceil(float64(num)/(float64(elapsed)/1000000000)))
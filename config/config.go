@@ -7,8 +7,25 @@ const (
 	RedisAddr string = "localhost:6379"
 	// RedisPoolSize 连接redis最多的连接(Maximum number of socket connections.).
 	RedisPoolSize int = 30
-	// TokenMaxExTime token生存时间.
+	// TokenMaxExTime token的空闲时间, 超过后token失效; 每次使用token后重新计时, 但不超过TokenMaxLifetime.
 	TokenMaxExTime int = 3600
+	// TokenMaxLifetime token从登录(或刷新)开始的最长生存时间, 也是cookie的生存时间.
+	TokenMaxLifetime int = 12 * 3600
+	// RefreshTokenExTime refresh token从登录开始的生存时间, 在此之前可以用它换取新的token.
+	RefreshTokenExTime int = 30 * 24 * 3600
+	// BenchmarkToken 压测使用的token, 任何用户都可以用它通过鉴权. 只能在压测环境中设置, benchmark使用它作为token cookie, 为空时关闭.
+	BenchmarkToken string = ""
+
+	// PasswordHashAlgorithm 保存密码使用的哈希算法(argon2id或bcrypt). 修改算法或参数后, 旧的哈希在用户登录时自动升级.
+	PasswordHashAlgorithm string = "argon2id"
+	// PasswordArgon2Memory argon2id使用的内存(KiB).
+	PasswordArgon2Memory uint32 = 19 * 1024
+	// PasswordArgon2Time argon2id的迭代次数.
+	PasswordArgon2Time uint32 = 2
+	// PasswordArgon2Threads argon2id的并行度.
+	PasswordArgon2Threads uint8 = 1
+	// PasswordBcryptCost bcrypt的cost.
+	PasswordBcryptCost int = 10
 
 	// MysqlDB 连接数据库地址.
 	MysqlDB string = "root:11111111@(127.0.0.1:3306)/test_db?charset=utf8"
@@ -22,10 +39,69 @@ const (
 
 	// TCPServerLogPath TCP服务日志.
 	TCPServerLogPath string = "./log/tcp_server.log"
-	// TCPServerAddr tcp server ip:port.
+	// TCPServerAddr tcp server地址(见rpc.ParseAddr), eg: ":3194", "tcp6://[::1]:3194", "unix:///tmp/usermana.sock".
+	// http server和tcp server在同一台机器上时可以使用unix domain socket.
 	TCPServerAddr string = ":3194"
-	// TCPClientPoolSize 客户端tcp连接池大小.
-	TCPClientPoolSize int = 2000
+	// TCPServerListFile tcp server地址列表文件(每行一个地址), 修改后http server自动连接新增的tcp server.
+	// 为空时只连接TCPServerAddr.
+	TCPServerListFile string = ""
+	// TCPClientPoolSize 客户端到每个tcp server的连接数, 每个连接上可以同时进行多个rpc请求.
+	TCPClientPoolSize int = 8
+	// RPCMaxMessageSize rpc单个消息体的最大长度.
+	RPCMaxMessageSize int = 4 << 20
+	// RPCCallTimeout http server调用rpc的超时时间.
+	RPCCallTimeout time.Duration = 3 * time.Second
+	// RPCTLSCAFile rpc双向TLS认证的CA证书, 为空时rpc不使用TLS. 证书文件更新后向进程发送SIGHUP重新加载.
+	RPCTLSCAFile string = ""
+	// RPCTLSServerName tcp server证书中的名字, http server据此校验tcp server.
+	RPCTLSServerName string = "usermana-tcp"
+	// TCPServerCertFile tcp server的证书.
+	TCPServerCertFile string = "./certs/tcp_server.pem"
+	// TCPServerKeyFile tcp server证书的私钥.
+	TCPServerKeyFile string = "./certs/tcp_server.key"
+	// HTTPServerCertFile http server调用rpc时使用的客户端证书.
+	HTTPServerCertFile string = "./certs/http_server.pem"
+	// HTTPServerKeyFile http server客户端证书的私钥.
+	HTTPServerKeyFile string = "./certs/http_server.key"
+	// RPCRetryAttempts http server调用幂等rpc方法的最多尝试次数(含第一次).
+	RPCRetryAttempts int = 3
+	// RPCRetryMinBackoff 重试的初始间隔, 每次翻倍.
+	RPCRetryMinBackoff time.Duration = 50 * time.Millisecond
+	// RPCRetryMaxBackoff 重试的最大间隔.
+	RPCRetryMaxBackoff time.Duration = 500 * time.Millisecond
+	// RPCConcurrencyLimit http server同时进行的rpc调用数的初始限制, 之后根据调用结果自适应调整.
+	RPCConcurrencyLimit int = 200
+	// RPCConcurrencyMinLimit 并发限制的下限.
+	RPCConcurrencyMinLimit int = 20
+	// RPCConcurrencyMaxLimit 并发限制的上限.
+	RPCConcurrencyMaxLimit int = 2000
+	// RPCBreakerFailures rpc方法连续失败多少次后熔断.
+	RPCBreakerFailures int = 5
+	// RPCBreakerCooldown 熔断后经过多久放行探测请求.
+	RPCBreakerCooldown time.Duration = 5 * time.Second
+	// SignUpMaxConcurrent tcp server同时执行SignUp的最大数量, 避免突发的注册请求占满MySQL连接而影响GetProfile.
+	SignUpMaxConcurrent int = 50
+	// SignUpMaxQueue SignUp达到并发上限后最多排队的请求数, 超过时返回服务繁忙.
+	SignUpMaxQueue int = 100
+	// SignUpRate 每秒最多处理的SignUp请求数.
+	SignUpRate float64 = 200
+	// LoginMaxConcurrent tcp server同时执行Login的最大数量. Login需要计算密码哈希, 很耗CPU,
+	// 限制并发避免突发的登录请求(或暴力破解)占满CPU而影响GetProfile等其他方法.
+	LoginMaxConcurrent int = 20
+	// LoginMaxQueue Login达到并发上限后最多排队的请求数, 超过时返回服务繁忙.
+	LoginMaxQueue int = 200
+	// LoginRate 每秒最多处理的Login请求数.
+	LoginRate float64 = 500
+	// TCPServerDebugAddr tcp server的调试地址, 通过/debug/vars查看各方法的配额使用情况(排队深度等), 为空时不开启.
+	TCPServerDebugAddr string = "localhost:6194"
+	// TCPServerAdminAddr tcp server的管理地址, 通过/admin/revokeSessions退出用户的所有会话.
+	// 只能是本机地址(否则tcp server拒绝启动), 为空时不开启.
+	TCPServerAdminAddr string = ""
+	// TCPServerAdminSecret 管理接口的密钥, 请求需要在X-Admin-Secret头中带上它. 开启管理地址时不能为空.
+	TCPServerAdminSecret string = ""
+
+	// ShutdownTimeout 收到SIGINT/SIGTERM后等待正在处理的请求完成的最长时间.
+	ShutdownTimeout time.Duration = 10 * time.Second
 
 	// HTTPServerLogPath HTTP服务日志.
 	HTTPServerLogPath string = "./log/http_server.log"
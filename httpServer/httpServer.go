@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"text/template"
+	"time"
 	"usermana/config"
 	"usermana/log"
 	"usermana/protocol"
@@ -28,6 +34,17 @@ type ProfileResponse struct {
 	UserName string
 	NickName string
 	PicName  string
+	Sessions []SessionResponse
+}
+
+// SessionResponse 用于在profile.html中展示一个登录会话.
+type SessionResponse struct {
+	ID        string
+	CreatedAt string
+	LastSeen  string
+	UserAgent string
+	IP        string
+	Current   bool
 }
 
 // JumpResponse 用于向jump.html模版传递参数.
@@ -35,7 +52,13 @@ type JumpResponse struct {
 	Msg string
 }
 
-var rpcClient rpc.RPCClient
+var rpcClient *rpc.RPCClient
+
+// userClient 通过rpcClient调用tcp server的User服务.
+var userClient *protocol.UserClient
+
+// rpcStats rpc调用统计, 通过/debug/vars查看.
+var rpcStats rpc.CallStats
 
 // init 提前解析html文件.程序用到即可直接使用，避免多次解析.
 func init() {
@@ -50,12 +73,40 @@ func main() {
 		panic(err)
 	}
 
-	//初始化rpc客户端并且连接rpc服务器.
+	//初始化rpc客户端并且连接rpc服务器, 有多个rpc服务器时选择正在处理的请求最少的一个.
+	var resolver rpc.Resolver = rpc.StaticResolver{config.TCPServerAddr}
+	if config.TCPServerListFile != "" {
+		resolver = rpc.FileResolver(config.TCPServerListFile)
+	}
+	opts := []rpc.Option{
+		rpc.MaxMessageSize(config.RPCMaxMessageSize),
+		rpc.UseCodec(rpc.CodecMsgpack),
+		rpc.LoadBalance(rpc.LeastOutstanding),
+	}
+	if config.RPCTLSCAFile != "" {
+		//使用客户端证书向tcp server证明身份, 并校验tcp server的证书.
+		certs, err := rpc.NewCertReloader(config.HTTPServerCertFile, config.HTTPServerKeyFile, config.RPCTLSCAFile)
+		if err != nil {
+			panic(err)
+		}
+		certs.ReloadOnSignal(syscall.SIGHUP)
+		opts = append(opts, rpc.TLS(certs.ClientConfig(config.RPCTLSServerName)))
+	}
 	var err error
-	rpcClient, err = rpc.Client(config.TCPClientPoolSize, config.TCPServerAddr)
+	rpcClient, err = rpc.ResolverClient(config.TCPClientPoolSize, resolver, opts...)
 	if err != nil {
 		panic(err)
 	}
+	//统一记录rpc调用的耗时和错误, 统计失败次数, 限制并发并按方法熔断, 重试幂等的方法.
+	//超过并发限制或熔断时调用立即返回Unavailable, 页面提示服务繁忙, 而不是让请求堆积.
+	limiter := rpc.NewConcurrencyLimiter(config.RPCConcurrencyLimit, config.RPCConcurrencyMinLimit, config.RPCConcurrencyMaxLimit)
+	breaker := rpc.NewCircuitBreaker(config.RPCBreakerFailures, config.RPCBreakerCooldown)
+	rpcClient.Use(rpc.LogInterceptor, rpcStats.Interceptor, limiter.Interceptor, breaker.Interceptor,
+		rpc.RetryInterceptor(config.RPCRetryAttempts, config.RPCRetryMinBackoff, config.RPCRetryMaxBackoff))
+	rpcClient.Idempotent("User.GetProfile")
+	userClient = protocol.NewUserClient(rpcClient)
+	expvar.Publish("rpc", expvar.Func(func() interface{} { return rpcStats.Snapshot() }))
+
 	// 静态文件服务.
 	//让文件服务器使用utils.StaticFilePath目录下的文件，响应url以/static/开头的http请求.
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(config.StaticFilePath))))
@@ -64,12 +115,37 @@ func main() {
 	http.HandleFunc("/", GetProfile)
 	http.HandleFunc("/signUp", SignUp)
 	http.HandleFunc("/login", Login)
+	http.HandleFunc("/logout", Logout)
 	http.HandleFunc("/profile", GetProfile)
 	http.HandleFunc("/updateNickName", UpdateNickName)
 	http.HandleFunc("/uploadFile", UploadProfilePicture)
+	http.HandleFunc("/revokeSession", RevokeSession)
+	http.HandleFunc("/revokeOtherSessions", RevokeOtherSessions)
+
+	//收到SIGINT/SIGTERM后等待正在处理的http请求完成再退出.
+	server := &http.Server{Addr: config.HTTPServerAddr}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		log.Infof("http: received %v, shutting down.", <-sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("http: shutdown failed. err:%q", err)
+		}
+	}()
 
 	//开启http server监听.
-	http.ListenAndServe(config.HTTPServerAddr, nil)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		panic(err)
+	}
+	<-done
+	//http请求都已完成, 关闭rpc连接池.
+	rpcClient.Close()
+	log.Infof("http: shutdown done.")
 }
 
 // SignUp 注册账号.
@@ -86,16 +162,19 @@ func SignUp(rw http.ResponseWriter, req *http.Request) {
 			return
 		}
 		fmt.Printf("userName = %s, password = %s,nickName = %s\n", userName, password, nickName)
+		//rpc调用最多等待RPCCallTimeout, 浏览器断开连接时也不再等待.
+		ctx, cancel := context.WithTimeout(req.Context(), config.RPCCallTimeout)
+		defer cancel()
+
 		req := protocol.ReqSignUp{
 			UserName: userName,
 			Password: password,
 			NickName: nickName,
 		}
-		resp := protocol.RespSignUp{}
 		//调用远程rpc服务, 将数据存入到数据库.
-		if err := rpcClient.Call("SignUp", req, &resp); err != nil {
-			log.Errorf("http.SignUp: Call SignUp failed. username:%s, err:%q", userName, err)
-			rw.Write([]byte("创建账号失败！"))
+		resp, err := userClient.SignUp(ctx, req)
+		if err != nil {
+			rw.Write([]byte(rpcFailedMsg(err, "创建账号失败！")))
 			return
 		}
 
@@ -123,25 +202,29 @@ func Login(rw http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		ctx, cancel := context.WithTimeout(req.Context(), config.RPCCallTimeout)
+		defer cancel()
+
 		req := protocol.ReqLogin{
-			UserName: userName,
-			Password: password,
+			UserName:  userName,
+			Password:  password,
+			UserAgent: req.UserAgent(),
+			IP:        clientIP(req),
 		}
-		resp := protocol.RespLogin{}
 		//调用远程rpc服务, 主要对登陆账号密码进行验证.
-		if err := rpcClient.Call("Login", req, &resp); err != nil {
-			log.Errorf("http.Login: Call Login failed. username:%s, err:%q", userName, err)
+		resp, err := userClient.Login(ctx, req)
+		if err != nil {
 			// 重新登录.
-			templateLogin(rw, LoginResponse{Msg: "登录失败！"})
+			templateLogin(rw, LoginResponse{Msg: rpcFailedMsg(err, "登录失败！")})
 			return
 		}
 
 		switch resp.Ret {
 		case 0:
-			//登陆成功将username,token作为Cookies发送给客户端.
-			cookie := http.Cookie{Name: "username", Value: userName, MaxAge: config.TokenMaxExTime}
+			//登陆成功将username,token作为Cookies发送给客户端. token在使用时会延长, cookie保留到token的最长生存时间.
+			cookie := http.Cookie{Name: "username", Value: userName, MaxAge: config.TokenMaxLifetime}
 			http.SetCookie(rw, &cookie)
-			cookie = http.Cookie{Name: "token", Value: resp.Token, MaxAge: config.TokenMaxExTime}
+			cookie = http.Cookie{Name: "token", Value: resp.Token, MaxAge: config.TokenMaxLifetime}
 			http.SetCookie(rw, &cookie)
 
 			templateJump(rw, JumpResponse{Msg: "登录成功！"})
@@ -154,6 +237,45 @@ func Login(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// Logout 退出登录, 删除服务端的会话并清除username和token cookie.
+// 删除会话失败时cookie也会被清除, 但提示用户退出失败(token在过期前仍然有效).
+func Logout(rw http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" {
+		token, tokenErr := req.Cookie("token")
+		nameCookie, nameErr := req.Cookie("username")
+		//清除cookie.
+		http.SetCookie(rw, &http.Cookie{Name: "username", Value: "", MaxAge: -1})
+		http.SetCookie(rw, &http.Cookie{Name: "token", Value: "", MaxAge: -1})
+		if tokenErr != nil || nameErr != nil {
+			templateLogin(rw, LoginResponse{Msg: "已退出登录！"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), config.RPCCallTimeout)
+		defer cancel()
+
+		req := protocol.ReqLogout{
+			UserName: nameCookie.Value,
+			Token:    token.Value,
+		}
+		//调用远程rpc服务, 删除会话.
+		resp, err := userClient.Logout(ctx, req)
+		if err != nil {
+			templateLogin(rw, LoginResponse{Msg: rpcFailedMsg(err, "退出登录失败！")})
+			return
+		}
+
+		switch resp.Ret {
+		case 0, 1:
+			//token已经失效(ret为1)时也视为退出成功.
+			templateLogin(rw, LoginResponse{Msg: "已退出登录！"})
+		default:
+			templateLogin(rw, LoginResponse{Msg: "退出登录失败！"})
+		}
+		log.Infof("http.Logout: Logout done. username:%s, ret:%d", nameCookie.Value, resp.Ret)
+	}
+}
+
 // GetProfile 获得用户信息.
 func GetProfile(rw http.ResponseWriter, req *http.Request) {
 	if req.Method == "GET" {
@@ -176,15 +298,17 @@ func GetProfile(rw http.ResponseWriter, req *http.Request) {
 			userName = nameCookie.Value
 		}
 
+		ctx, cancel := context.WithTimeout(req.Context(), config.RPCCallTimeout)
+		defer cancel()
+
 		req := protocol.ReqGetProfile{
 			UserName: userName,
 			Token:    token.Value,
 		}
-		resp := protocol.RespGetProfile{}
 		//调用远程rpc服务, 获取用户对应的信息.
-		if err := rpcClient.Call("GetProfile", req, &resp); err != nil {
-			log.Errorf("http.GetProfile: Call GetProfile failed. username:%s, err:%q", userName, err)
-			templateJump(rw, JumpResponse{Msg: "获取用户信息失败！"})
+		resp, err := userClient.GetProfile(ctx, req)
+		if err != nil {
+			templateJump(rw, JumpResponse{Msg: rpcFailedMsg(err, "获取用户信息失败！")})
 			return
 		}
 
@@ -193,11 +317,12 @@ func GetProfile(rw http.ResponseWriter, req *http.Request) {
 			if resp.PicName == "" {
 				resp.PicName = config.DefaultImagePath
 			}
-			//将用户的信息返回给对应的用户.
+			//将用户的信息和登录的设备返回给对应的用户.
 			templateProfile(rw, ProfileResponse{
 				UserName: resp.UserName,
 				NickName: resp.NickName,
-				PicName:  resp.PicName})
+				PicName:  resp.PicName,
+				Sessions: listSessions(ctx, userName, token.Value)})
 		case 1:
 			templateLogin(rw, LoginResponse{Msg: "请重新登录！"})
 		case 2:
@@ -222,16 +347,18 @@ func UpdateNickName(rw http.ResponseWriter, req *http.Request) {
 		userName := req.FormValue("username")
 		nickName := req.FormValue("nickname")
 
+		ctx, cancel := context.WithTimeout(req.Context(), config.RPCCallTimeout)
+		defer cancel()
+
 		req := protocol.ReqUpdateNickName{
 			UserName: userName,
 			NickName: nickName,
 			Token:    token.Value,
 		}
-		resp := protocol.RespUpdateNickName{}
 		//调用远程rpc服务, 修改用户的nickName信息.
-		if err := rpcClient.Call("UpdateNickName", req, &resp); err != nil {
-			log.Errorf("http.UpdateNickName: Call UpdateNickName failed. username:%s, err:%q", userName, err)
-			templateJump(rw, JumpResponse{Msg: "修改头像失败！"})
+		resp, err := userClient.UpdateNickName(ctx, req)
+		if err != nil {
+			templateJump(rw, JumpResponse{Msg: rpcFailedMsg(err, "修改头像失败！")})
 			return
 		}
 
@@ -287,16 +414,18 @@ func UploadProfilePicture(rw http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		ctx, cancel := context.WithTimeout(req.Context(), config.RPCCallTimeout)
+		defer cancel()
+
 		req := protocol.ReqUpdateProfilePic{
 			UserName: userName,
 			FileName: serverPath,
 			Token:    token.Value,
 		}
-		resp := protocol.RespUpdateProfilePic{}
 		//调用远程rpc服务, 修改用户的头像pickName的路径
-		if err := rpcClient.Call("UpdateProfilePic", req, &resp); err != nil {
-			log.Errorf("http.UploadProfilePicture: Call UploadProfilePic failed. username:%s, err:%q", userName, err)
-			templateJump(rw, JumpResponse{Msg: "修改头像失败！"})
+		resp, err := userClient.UpdateProfilePic(ctx, req)
+		if err != nil {
+			templateJump(rw, JumpResponse{Msg: rpcFailedMsg(err, "修改头像失败！")})
 			return
 		}
 
@@ -314,6 +443,125 @@ func UploadProfilePicture(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// RevokeSession 退出指定的登录会话(设备).
+func RevokeSession(rw http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" {
+		// 获取token, 没有token则重新登陆.
+		token, err := req.Cookie("token")
+		if err != nil {
+			log.Errorf("http.RevokeSession: get token failed. err:%q", err)
+			templateLogin(rw, LoginResponse{})
+			return
+		}
+		userName := req.FormValue("username")
+		sessionID := req.FormValue("session_id")
+
+		ctx, cancel := context.WithTimeout(req.Context(), config.RPCCallTimeout)
+		defer cancel()
+
+		req := protocol.ReqRevokeSession{
+			UserName:  userName,
+			Token:     token.Value,
+			SessionID: sessionID,
+		}
+		//调用远程rpc服务, 删除会话.
+		resp, err := userClient.RevokeSession(ctx, req)
+		if err != nil {
+			templateJump(rw, JumpResponse{Msg: rpcFailedMsg(err, "退出设备失败！")})
+			return
+		}
+
+		switch resp.Ret {
+		case 0:
+			templateJump(rw, JumpResponse{Msg: "退出设备成功！"})
+		case 1:
+			templateLogin(rw, LoginResponse{Msg: "请重新登录！"})
+		case 2:
+			templateJump(rw, JumpResponse{Msg: "设备已退出！"})
+		default:
+			templateJump(rw, JumpResponse{Msg: "退出设备失败！"})
+		}
+		log.Infof("http.RevokeSession: RevokeSession done. username:%s, session:%s, ret:%d", userName, sessionID, resp.Ret)
+	}
+}
+
+// RevokeOtherSessions 退出当前设备以外的所有登录会话.
+func RevokeOtherSessions(rw http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" {
+		// 获取token, 没有token则重新登陆.
+		token, err := req.Cookie("token")
+		if err != nil {
+			log.Errorf("http.RevokeOtherSessions: get token failed. err:%q", err)
+			templateLogin(rw, LoginResponse{})
+			return
+		}
+		userName := req.FormValue("username")
+
+		ctx, cancel := context.WithTimeout(req.Context(), config.RPCCallTimeout)
+		defer cancel()
+
+		req := protocol.ReqRevokeOtherSessions{
+			UserName: userName,
+			Token:    token.Value,
+		}
+		//调用远程rpc服务, 删除其他会话.
+		resp, err := userClient.RevokeOtherSessions(ctx, req)
+		if err != nil {
+			templateJump(rw, JumpResponse{Msg: rpcFailedMsg(err, "退出其他设备失败！")})
+			return
+		}
+
+		switch resp.Ret {
+		case 0:
+			templateJump(rw, JumpResponse{Msg: fmt.Sprintf("已退出%d个其他设备！", resp.Revoked)})
+		case 1:
+			templateLogin(rw, LoginResponse{Msg: "请重新登录！"})
+		default:
+			templateJump(rw, JumpResponse{Msg: "退出其他设备失败！"})
+		}
+		log.Infof("http.RevokeOtherSessions: RevokeOtherSessions done. username:%s, ret:%d, revoked:%d", userName, resp.Ret, resp.Revoked)
+	}
+}
+
+// listSessions 获取用户登录的设备, 用于在用户信息页面中展示. 获取失败时只记录日志, 页面不展示设备.
+func listSessions(ctx context.Context, userName, token string) []SessionResponse {
+	resp, err := userClient.ListSessions(ctx, protocol.ReqListSessions{UserName: userName, Token: token})
+	if err != nil || resp.Ret != 0 {
+		log.Errorf("http.GetProfile: ListSessions failed. username:%s, ret:%d, err:%v", userName, resp.Ret, err)
+		return nil
+	}
+	sessions := make([]SessionResponse, 0, len(resp.Sessions))
+	for _, s := range resp.Sessions {
+		sessions = append(sessions, SessionResponse{
+			ID:        s.ID,
+			CreatedAt: time.Unix(s.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+			LastSeen:  time.Unix(s.LastSeen, 0).Format("2006-01-02 15:04:05"),
+			UserAgent: s.UserAgent,
+			IP:        s.IP,
+			Current:   s.Current,
+		})
+	}
+	return sessions
+}
+
+// clientIP 返回请求的客户端IP.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// rpcFailedMsg 返回rpc调用失败时展示的信息. 服务不可用(熔断、超过并发限制或连接断开)
+// 或超过tcp server的配额时提示服务繁忙, 否则返回msg.
+func rpcFailedMsg(err error, msg string) string {
+	if code := rpc.CodeOf(err); code == rpc.Unavailable || code == rpc.ResourceExhausted {
+		return "服务繁忙，请稍后重试！"
+	}
+	return msg
+}
+
 //http 登陆页面.
 func templateLogin(rw http.ResponseWriter, resp LoginResponse) {
 	if err := loginTemplate.Execute(rw, resp); err != nil {
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"usermana/protocol"
+	"usermana/rpc"
+)
+
+// fakeUser 测试用的User服务, 在内存中保存用户和会话, 代替tcp server、MySQL和Redis.
+type fakeUser struct {
+	mu       sync.Mutex
+	users    map[string]protocol.ReqSignUp
+	sessions map[string]map[string]protocol.Session // 用户名 -> token -> 会话.
+	logins   int
+}
+
+// SignUp 注册用户, 用户名重复时返回2.
+func (f *fakeUser) SignUp(ctx context.Context, req protocol.ReqSignUp) (resp protocol.RespSignUp, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.users[req.UserName]; ok {
+		resp.Ret = 2
+		return
+	}
+	f.users[req.UserName] = req
+	return
+}
+
+// Login 校验密码并生成token.
+func (f *fakeUser) Login(ctx context.Context, req protocol.ReqLogin) (resp protocol.RespLogin, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, ok := f.users[req.UserName]; !ok || user.Password != req.Password {
+		resp.Ret = 1
+		return
+	}
+	f.logins++
+	resp.Token = fmt.Sprintf("token-%s-%d", req.UserName, f.logins)
+	if f.sessions[req.UserName] == nil {
+		f.sessions[req.UserName] = make(map[string]protocol.Session)
+	}
+	f.sessions[req.UserName][resp.Token] = protocol.Session{ID: "id-" + resp.Token, UserAgent: req.UserAgent, IP: req.IP}
+	return
+}
+
+// GetProfile 校验token并返回用户信息.
+func (f *fakeUser) GetProfile(ctx context.Context, req protocol.ReqGetProfile) (resp protocol.RespGetProfile, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.valid(req.UserName, req.Token) {
+		resp.Ret = protocol.RetTokenInvalid
+		return
+	}
+	user := f.users[req.UserName]
+	resp.UserName, resp.NickName = user.UserName, user.NickName
+	return
+}
+
+// Logout 删除token对应的会话.
+func (f *fakeUser) Logout(ctx context.Context, req protocol.ReqLogout) (resp protocol.RespLogout, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.valid(req.UserName, req.Token) {
+		resp.Ret = protocol.RetTokenInvalid
+		return
+	}
+	delete(f.sessions[req.UserName], req.Token)
+	return
+}
+
+// ListSessions 返回用户的所有会话.
+func (f *fakeUser) ListSessions(ctx context.Context, req protocol.ReqListSessions) (resp protocol.RespListSessions, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.valid(req.UserName, req.Token) {
+		resp.Ret = protocol.RetTokenInvalid
+		return
+	}
+	for token, s := range f.sessions[req.UserName] {
+		s.Current = token == req.Token
+		resp.Sessions = append(resp.Sessions, s)
+	}
+	return
+}
+
+// RevokeSession 删除会话id对应的会话.
+func (f *fakeUser) RevokeSession(ctx context.Context, req protocol.ReqRevokeSession) (resp protocol.RespRevokeSession, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.valid(req.UserName, req.Token) {
+		resp.Ret = protocol.RetTokenInvalid
+		return
+	}
+	for token, s := range f.sessions[req.UserName] {
+		if s.ID == req.SessionID {
+			delete(f.sessions[req.UserName], token)
+			return
+		}
+	}
+	resp.Ret = 2
+	return
+}
+
+// RevokeOtherSessions 删除当前会话以外的所有会话.
+func (f *fakeUser) RevokeOtherSessions(ctx context.Context, req protocol.ReqRevokeOtherSessions) (resp protocol.RespRevokeOtherSessions, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.valid(req.UserName, req.Token) {
+		resp.Ret = protocol.RetTokenInvalid
+		return
+	}
+	for token := range f.sessions[req.UserName] {
+		if token != req.Token {
+			delete(f.sessions[req.UserName], token)
+			resp.Revoked++
+		}
+	}
+	return
+}
+
+// valid 判断token是否是用户的一个会话, 调用者需持有f.mu.
+func (f *fakeUser) valid(userName, token string) bool {
+	_, ok := f.sessions[userName][token]
+	return token != "" && ok
+}
+
+// useFakeUser 将rpcClient替换为连接到进程内fakeUser服务的客户端.
+func useFakeUser(t *testing.T) {
+	server := rpc.Server()
+	fake := &fakeUser{users: make(map[string]protocol.ReqSignUp), sessions: make(map[string]map[string]protocol.Session)}
+	if err := server.RegisterServiceName("User", fake); err != nil {
+		t.Fatalf("RegisterServiceName failed. err:%v", err)
+	}
+	client, err := rpc.InMemoryClient(server, rpc.UseCodec(rpc.CodecMsgpack))
+	if err != nil {
+		t.Fatalf("InMemoryClient failed. err:%v", err)
+	}
+	rpcClient, userClient = client, protocol.NewUserClient(client)
+	t.Cleanup(func() { client.Close() })
+}
+
+// TestHandlers 通过httptest依次测试注册、登录、获取用户信息和退出设备的http接口.
+func TestHandlers(t *testing.T) {
+	useFakeUser(t)
+
+	var cookies []*http.Cookie
+	var tests = []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		form    url.Values
+		cookie  bool // 是否带上登录成功时设置的cookie.
+		want    string
+	}{
+		{"SignUp", SignUp, "POST", url.Values{"username": {"bot1"}, "password": {"123"}, "nickname": {"botNick1"}}, false, "创建账号成功！"},
+		{"SignUp", SignUp, "POST", url.Values{"username": {"bot1"}, "password": {"456"}}, false, "创建账号失败！"},
+		{"SignUp", SignUp, "POST", url.Values{"username": {"bot2"}}, false, "Username and password couldn't be NULL!"},
+		{"Login", Login, "POST", url.Values{"username": {"bot1"}, "password": {"456"}}, false, "用户名或密码错误！"},
+		{"GetProfile", GetProfile, "GET", nil, false, `action="/login"`},
+		{"Login", Login, "POST", url.Values{"username": {"bot1"}, "password": {"123"}}, false, "登录成功！"},
+		{"GetProfile", GetProfile, "GET", nil, true, "botNick1"},
+		{"GetProfile", GetProfile, "GET", url.Values{"username": {"bot2"}}, true, "请重新登录！"},
+		{"Login", Login, "POST", url.Values{"username": {"bot1"}, "password": {"123"}}, false, "登录成功！"},
+		{"GetProfile", GetProfile, "GET", nil, true, "Sign out (this device)"},
+		{"GetProfile", GetProfile, "GET", nil, true, "192.0.2.1"},
+		{"RevokeOtherSessions", RevokeOtherSessions, "POST", url.Values{"username": {"bot1"}}, true, "已退出1个其他设备！"},
+		{"RevokeSession", RevokeSession, "POST", url.Values{"username": {"bot1"}, "session_id": {"id-token-bot1-1"}}, true, "设备已退出！"},
+		{"RevokeSession", RevokeSession, "POST", url.Values{"username": {"bot1"}, "session_id": {"id-token-bot1-2"}}, true, "退出设备成功！"},
+		{"GetProfile", GetProfile, "GET", nil, true, "请重新登录！"},
+		{"Login", Login, "POST", url.Values{"username": {"bot1"}, "password": {"123"}}, false, "登录成功！"},
+		{"GetProfile", GetProfile, "GET", nil, true, "botNick1"},
+	}
+	for _, test := range tests {
+		var req *http.Request
+		if test.method == "POST" {
+			req = httptest.NewRequest(test.method, "/", strings.NewReader(test.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(test.method, "/?"+test.form.Encode(), nil)
+		}
+		if test.cookie {
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+		}
+		rec := httptest.NewRecorder()
+		test.handler(rec, req)
+
+		if body := rec.Body.String(); !strings.Contains(body, test.want) {
+			t.Errorf("%s didn't pass. form:%v, want:%s, body:%s", test.name, test.form, test.want, body)
+		}
+		if c := rec.Result().Cookies(); len(c) > 0 {
+			cookies = c
+		}
+	}
+	if len(cookies) != 2 || cookies[1].Name != "token" || cookies[1].Value != "token-bot1-3" {
+		t.Errorf("Login didn't set cookies. cookies:%v", cookies)
+	}
+}
+
+// TestLogout 测试退出登录的http接口, 退出后服务端的会话被删除, cookie被清除.
+func TestLogout(t *testing.T) {
+	useFakeUser(t)
+	for _, handler := range []http.HandlerFunc{SignUp, Login} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("username=bot1&password=123"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler(httptest.NewRecorder(), req)
+	}
+	cookies := []*http.Cookie{{Name: "username", Value: "bot1"}, {Name: "token", Value: "token-bot1-1"}}
+
+	var tests = []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		want    string
+	}{
+		{"Logout", Logout, "GET", ""},
+		{"GetProfile", GetProfile, "GET", "Log out"},
+		{"Logout", Logout, "POST", "已退出登录！"},
+		{"GetProfile", GetProfile, "GET", "请重新登录！"},
+		{"Logout", Logout, "POST", "已退出登录！"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		test.handler(rec, req)
+		if body := rec.Body.String(); !strings.Contains(body, test.want) {
+			t.Errorf("%s didn't pass. method:%s, want:%s, body:%s", test.name, test.method, test.want, body)
+		}
+		if test.name != "Logout" || test.method != "POST" {
+			continue
+		}
+		c := rec.Result().Cookies()
+		if len(c) != 2 || c[0].Name != "username" || c[1].Name != "token" || c[0].MaxAge >= 0 || c[1].MaxAge >= 0 {
+			t.Errorf("Logout didn't clear cookies. cookies:%v", c)
+		}
+	}
+}
@@ -1,15 +1,19 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"usermana/config"
-	"usermana/utils"
+	"usermana/log"
+	"usermana/password"
 
 	_ "github.com/go-sql-driver/mysql" // mysqldrive
 )
 
 var (
+	db *sql.DB
+
 	createAccountSt    *sql.Stmt
 	loginAuthSt        *sql.Stmt
 	createProfileSt    *sql.Stmt
@@ -17,12 +21,17 @@ var (
 	updateProfileSt    *sql.Stmt
 	updateNickNameSt   *sql.Stmt
 	updateProfilePicSt *sql.Stmt
+	updatePasswordSt   *sql.Stmt
 )
 
+// dummyHash 用户不存在时也校验一次密码, 使登录的耗时与用户是否存在无关.
+var dummyHash, _ = password.Hash("usermana")
+
 //init,  mysql的初始化函数.
 func init() {
 	//连接数据库
-	db, err := sql.Open("mysql", config.MysqlDB)
+	var err error
+	db, err = sql.Open("mysql", config.MysqlDB)
 	if err != nil {
 		panic(err)
 	}
@@ -44,10 +53,21 @@ func init() {
 	updateProfileSt = dbPrepare(db, "UPDATE tbl_user_info SET nick_name = ?, pic_name = ? where user_name = ?")
 	updateNickNameSt = dbPrepare(db, "UPDATE tbl_user_info SET nick_name = ? where user_name = ?")
 	updateProfilePicSt = dbPrepare(db, "UPDATE tbl_user_info SET pic_name = ? where user_name = ?")
+	//只有密码哈希没有被同时修改时才升级.
+	updatePasswordSt = dbPrepare(db, "UPDATE tbl_login_info SET password = ? where user_name = ? and password = ?")
 
 	fmt.Println("mysql init done.")
 }
 
+// Close 关闭预处理的语句和数据库连接池, 在程序退出前调用.
+func Close() error {
+	for _, stmt := range []*sql.Stmt{createAccountSt, loginAuthSt, createProfileSt, getProfileSt,
+		updateProfileSt, updateNickNameSt, updateProfilePicSt, updatePasswordSt} {
+		stmt.Close()
+	}
+	return db.Close()
+}
+
 //dbPrepare 预处理sql语句.
 func dbPrepare(db *sql.DB, query string) *sql.Stmt {
 	stmt, err := db.Prepare(query)
@@ -58,10 +78,13 @@ func dbPrepare(db *sql.DB, query string) *sql.Stmt {
 }
 
 // CreateAccount 创建账号.
-func CreateAccount(userName string, password string) error {
-	//先对密码进行sha256的编码再保存到数据库.
-	pwd := utils.Sha256(password)
-	_, err := createAccountSt.Exec(userName, pwd)
+func CreateAccount(ctx context.Context, userName string, pwd string) error {
+	//只保存密码的哈希(带盐, 见password.Hash).
+	hash, err := password.Hash(pwd)
+	if err != nil {
+		return err
+	}
+	_, err = createAccountSt.ExecContext(ctx, userName, hash)
 	if err != nil {
 		return err
 	}
@@ -69,8 +92,8 @@ func CreateAccount(userName string, password string) error {
 }
 
 // CheckAccountExist  判断账号是否存在.
-func CheckAccountExist(userName string) (bool, error) {
-	rows, err := loginAuthSt.Query(userName)
+func CheckAccountExist(ctx context.Context, userName string) (bool, error) {
+	rows, err := loginAuthSt.QueryContext(ctx, userName)
 	if err != nil {
 		return false, err
 	}
@@ -81,35 +104,57 @@ func CheckAccountExist(userName string) (bool, error) {
 	return false, nil
 }
 
-// LoginAuth 登录校验.
-func LoginAuth(userName string, password string) (bool, error) {
-	var pwd string
+// LoginAuth 登录校验. 保存的是旧版的sha256或者参数已经过时的哈希时, 校验成功后用当前的算法重新生成哈希.
+func LoginAuth(ctx context.Context, userName string, pwd string) (bool, error) {
+	var hash string
 	//t := time.Now()
-	rows, err := loginAuthSt.Query(userName)
+	rows, err := loginAuthSt.QueryContext(ctx, userName)
 	if err != nil {
 		return false, err
 	}
 	//连接归还到连接池中
 	defer rows.Close()
 	//从数据库中过去用户密码.
+	found := false
 	for rows.Next() {
-		err = rows.Scan(&pwd)
+		err = rows.Scan(&hash)
+		found = true
 	}
 
 	if err != nil {
 		return false, err
 	}
+	if !found {
+		password.Verify(pwd, dummyHash)
+		return false, nil
+	}
 	//进行校验.
-	if pwd == utils.Sha256(password) {
-		//log.Infof("%q", time.Since(t))
-		return true, nil
+	ok, rehash, err := password.Verify(pwd, hash)
+	if err != nil || !ok {
+		return false, err
 	}
-	return false, nil
+	if rehash {
+		upgradePassword(ctx, userName, pwd, hash)
+	}
+	return true, nil
+}
+
+// upgradePassword 用当前的算法和参数重新生成密码哈希, 失败时只记录日志, 下次登录时再升级.
+func upgradePassword(ctx context.Context, userName string, pwd string, oldHash string) {
+	hash, err := password.Hash(pwd)
+	if err == nil {
+		_, err = updatePasswordSt.ExecContext(ctx, hash, userName, oldHash)
+	}
+	if err != nil {
+		log.Errorf("mysql.LoginAuth: upgrade password hash failed. username:%s, err:%q", userName, err)
+		return
+	}
+	log.Infof("mysql.LoginAuth: password hash upgraded. username:%s", userName)
 }
 
 // CreateProfile 创建用户信息.
-func CreateProfile(userName string, nickName string) error {
-	_, err := createProfileSt.Exec(userName, nickName)
+func CreateProfile(ctx context.Context, userName string, nickName string) error {
+	_, err := createProfileSt.ExecContext(ctx, userName, nickName)
 	if err != nil {
 		return err
 	}
@@ -117,8 +162,8 @@ func CreateProfile(userName string, nickName string) error {
 }
 
 // GetProfile 获取用户信息.
-func GetProfile(userName string) (nickName string, picName string, hasData bool, err error) {
-	rows, err := getProfileSt.Query(userName)
+func GetProfile(ctx context.Context, userName string) (nickName string, picName string, hasData bool, err error) {
+	rows, err := getProfileSt.QueryContext(ctx, userName)
 	if err != nil {
 		return nickName, picName, hasData, err
 	}
@@ -137,8 +182,8 @@ func GetProfile(userName string) (nickName string, picName string, hasData bool,
 }
 
 // CheckProfileExist 判断用户信息是否存在.
-func CheckProfileExist(userName string) (bool, error) {
-	rows, err := getProfileSt.Query(userName)
+func CheckProfileExist(ctx context.Context, userName string) (bool, error) {
+	rows, err := getProfileSt.QueryContext(ctx, userName)
 	if err != nil {
 		return false, err
 	}
@@ -150,20 +195,20 @@ func CheckProfileExist(userName string) (bool, error) {
 }
 
 // UpdateProfile 更新用户信息.
-func UpdateProfile(userName string, nickName string, picName string) (bool, error) {
-	res, err := updateProfileSt.Exec(nickName, picName, userName)
+func UpdateProfile(ctx context.Context, userName string, nickName string, picName string) (bool, error) {
+	res, err := updateProfileSt.ExecContext(ctx, nickName, picName, userName)
 	if err != nil {
 		return false, err
 	}
 	if afrows, _ := res.RowsAffected(); afrows > 0 {
 		return true, nil
 	}
-	return CheckProfileExist(userName)
+	return CheckProfileExist(ctx, userName)
 }
 
 // UpdateNikcName 更新用户昵称.
-func UpdateNikcName(userName string, nickName string) (bool, error) {
-	_, err := updateNickNameSt.Exec(nickName, userName)
+func UpdateNikcName(ctx context.Context, userName string, nickName string) (bool, error) {
+	_, err := updateNickNameSt.ExecContext(ctx, nickName, userName)
 	if err != nil {
 		return false, err
 	}
@@ -171,8 +216,8 @@ func UpdateNikcName(userName string, nickName string) (bool, error) {
 }
 
 // UpdateProfilePic 更新用户头像.
-func UpdateProfilePic(userName string, picName string) (bool, error) {
-	_, err := updateProfilePicSt.Exec(picName, userName)
+func UpdateProfilePic(ctx context.Context, userName string, picName string) (bool, error) {
+	_, err := updateProfilePicSt.ExecContext(ctx, picName, userName)
 	if err != nil {
 		return false, err
 	}
@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	// "math/rand"
 	"strconv"
 	"testing"
@@ -11,10 +12,10 @@ import (
 func TestCreateAccount100(t *testing.T) {
 	for i := 0; i < 10000000; i++ {
 		userName := "bot" + strconv.Itoa(i)
-		if err := CreateAccount(userName, "1234"); err != nil {
+		if err := CreateAccount(context.Background(), userName, "1234"); err != nil {
 			t.Errorf("CreateAccount didn't pass. username:%s, err:%q", userName, err)
 		}
-		if err := CreateProfile(userName, "bot"); err != nil {
+		if err := CreateProfile(context.Background(), userName, "bot"); err != nil {
 			t.Errorf("CreateProfile didn't pass. username:%s, err:%q", userName, err)
 		}
 		if i%100 == 0 {
@@ -33,7 +34,7 @@ func TestCreateAccount(t *testing.T) {
 		{"botTest", "1234"},
 	}
 	for _, test := range tests {
-		if err := CreateAccount(test.userName, test.password); err != nil {
+		if err := CreateAccount(context.Background(), test.userName, test.password); err != nil {
 			t.Errorf("CreateAccount didn't pass. username:%s, password:%s, err:%q", test.userName, test.password, err)
 		}
 	}
@@ -49,7 +50,7 @@ func TestCheckAccountExist(t *testing.T) {
 		{"noExist", false},
 	}
 	for _, test := range tests {
-		if ok, err := CheckAccountExist(test.userName); err != nil || ok != test.exist {
+		if ok, err := CheckAccountExist(context.Background(), test.userName); err != nil || ok != test.exist {
 			t.Errorf("CheckAccountExist didn't pass. userName:%s, exist:%t", test.userName, test.exist)
 		}
 	}
@@ -69,7 +70,7 @@ func TestLoginAuth(t *testing.T) {
 		{"", "123", false},
 	}
 	for _, test := range tests {
-		if ok, err := LoginAuth(test.userName, test.password); err != nil || ok != test.ok {
+		if ok, err := LoginAuth(context.Background(), test.userName, test.password); err != nil || ok != test.ok {
 			t.Errorf("LoginAuth didn't pass. userName:%s, password:%s, ok:%t", test.userName, test.password, test.ok)
 		}
 	}
@@ -84,7 +85,7 @@ func TestCreateProfile(t *testing.T) {
 		{"bot439", "botAAB"},
 	}
 	for _, test := range tests {
-		if err := CreateProfile(test.userName, test.nickName); err != nil {
+		if err := CreateProfile(context.Background(), test.userName, test.nickName); err != nil {
 			t.Errorf("CreateProfile didn't pass. username:%s, nickName:%s, err:%q", test.userName, test.nickName, err)
 		}
 	}
@@ -102,7 +103,7 @@ func TestGetProfile(t *testing.T) {
 		{"", false},
 	}
 	for _, test := range tests {
-		if _, _, ok, err := GetProfile(test.userName); err != nil || ok != test.hasData {
+		if _, _, ok, err := GetProfile(context.Background(), test.userName); err != nil || ok != test.hasData {
 			t.Errorf("GetProfile didn't pass. userName:%s, hasdata:%t", test.userName, test.hasData)
 		}
 	}
@@ -118,7 +119,7 @@ func TestCheckProfileExist(t *testing.T) {
 		{"noExist", false},
 	}
 	for _, test := range tests {
-		if ok, err := CheckProfileExist(test.userName); err != nil || ok != test.exist {
+		if ok, err := CheckProfileExist(context.Background(), test.userName); err != nil || ok != test.exist {
 			t.Errorf("CheckProfileExist didn't pass. userName:%s, exist:%t", test.userName, test.exist)
 		}
 	}
@@ -132,7 +133,7 @@ func TestUpdateProfile(t *testing.T) {
 		{"bot2", "soy1234", ""},
 	}
 	for _, test := range tests {
-		if _, err := UpdateProfile(test.userName, test.nickName, test.picName); err != nil {
+		if _, err := UpdateProfile(context.Background(), test.userName, test.nickName, test.picName); err != nil {
 			t.Errorf("UpdateProfile didn't pass. userName:%s, nickName:%s, picName:%s", test.userName, test.nickName, test.picName)
 		}
 	}
@@ -146,7 +147,7 @@ func TestUpdateNikcName(t *testing.T) {
 		{"bot2", "soy12345"},
 	}
 	for _, test := range tests {
-		if _, err := UpdateNikcName(test.userName, test.nickName); err != nil {
+		if _, err := UpdateNikcName(context.Background(), test.userName, test.nickName); err != nil {
 			t.Errorf("UpdateNikcName didn't pass. userName:%s, nickName:%s", test.userName, test.nickName)
 		}
 	}
@@ -160,7 +161,7 @@ func TestUpdateProfilePic(t *testing.T) {
 		{"bot2", "http://127.0.0.1:1188/static/default.jpeg"},
 	}
 	for _, test := range tests {
-		if _, err := UpdateProfilePic(test.userName, test.picName); err != nil {
+		if _, err := UpdateProfilePic(context.Background(), test.userName, test.picName); err != nil {
 			t.Errorf("UpdateProfilePic didn't pass. userName:%s, picName:%s", test.userName, test.picName)
 		}
 	}
@@ -175,7 +176,7 @@ func BenchmarkUpdateNikcName(b *testing.B) {
 	}
 	for _, test := range tests {
 		for i := 0; i < b.N; i++ {
-			if _, err := UpdateNikcName(test.userName, test.nickName); err != nil {
+			if _, err := UpdateNikcName(context.Background(), test.userName, test.nickName); err != nil {
 				b.Errorf("UpdateNikcName didn't pass. userName:%s, nickName:%s", test.userName, test.nickName)
 			}
 		}
@@ -192,7 +193,7 @@ func BenchmarkLoginSame(b *testing.B) {
 	}
 	for _, test := range tests {
 		for i := 0; i < b.N; i++ {
-			if _, err := LoginAuth(test.userName, test.password); err != nil {
+			if _, err := LoginAuth(context.Background(), test.userName, test.password); err != nil {
 				b.Errorf("LoginAuth didn't pass. userName:%s, password:%s", test.userName, test.password)
 			}
 		}
@@ -203,8 +204,8 @@ func BenchmarkLoginSame(b *testing.B) {
 func BenchmarkLoginRadom(b *testing.B) {
 	// b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		// if _, err := LoginAuth("bot"+strconv.Itoa(rand.Intn(10000000)), "123"); err != nil {
-		if _, err := LoginAuth("bot"+strconv.Itoa(b.N), "123"); err != nil {
+		// if _, err := LoginAuth(context.Background(), "bot"+strconv.Itoa(rand.Intn(10000000)), "123"); err != nil {
+		if _, err := LoginAuth(context.Background(), "bot"+strconv.Itoa(b.N), "123"); err != nil {
 			b.Errorf("LoginAuth didn't pass.")
 		}
 	}
@@ -0,0 +1,150 @@
+// Package password 生成和校验密码哈希.
+//
+// 哈希是自描述的字符串, 包含算法、参数和盐, 因此调整参数或更换算法后旧的哈希仍然可以校验:
+//
+//	argon2id: $argon2id$v=19$m=19456,t=2,p=1$<盐(base64)>$<哈希(base64)>
+//	bcrypt:   $2a$10$<盐和哈希>
+//	旧版:     64位十六进制的sha256(password), 没有盐
+//
+// Verify在哈希不是当前默认的算法和参数时返回needsRehash, 调用者应当用Hash重新生成并保存,
+// 这样旧版的哈希会在用户下次登录时升级, 不需要用户重置密码.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"usermana/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 支持的算法.
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+const (
+	saltSize   = 16 // argon2id盐的长度.
+	argon2Size = 32 // argon2id哈希的长度.
+)
+
+// ErrBadHash 哈希的格式无法识别.
+var ErrBadHash = errors.New("password: unrecognized hash format")
+
+// Params 生成哈希使用的算法和参数.
+type Params struct {
+	Algorithm string // Argon2id或Bcrypt.
+	Memory    uint32 // argon2id使用的内存(KiB).
+	Time      uint32 // argon2id的迭代次数.
+	Threads   uint8  // argon2id的并行度.
+	Cost      int    // bcrypt的cost.
+}
+
+// DefaultParams Hash和Verify使用的默认算法和参数.
+var DefaultParams = Params{
+	Algorithm: config.PasswordHashAlgorithm,
+	Memory:    config.PasswordArgon2Memory,
+	Time:      config.PasswordArgon2Time,
+	Threads:   config.PasswordArgon2Threads,
+	Cost:      config.PasswordBcryptCost,
+}
+
+// Hash 使用DefaultParams生成password的哈希.
+func Hash(password string) (string, error) {
+	return DefaultParams.Hash(password)
+}
+
+// Verify 使用DefaultParams校验password是否与哈希encoded匹配, 比较的耗时与密码是否正确无关.
+// 匹配且encoded不是用DefaultParams生成的(包括旧版的sha256)时needsRehash为true.
+func Verify(password, encoded string) (ok bool, needsRehash bool, err error) {
+	return DefaultParams.Verify(password, encoded)
+}
+
+// Hash 使用随机的盐生成password的哈希.
+func (p Params) Hash(password string) (string, error) {
+	switch p.Algorithm {
+	case Argon2id:
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2Size)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.Cost)
+		return string(hash), err
+	}
+	return "", fmt.Errorf("password: unknown algorithm %q", p.Algorithm)
+}
+
+// Verify 校验password是否与哈希encoded匹配, encoded不是用p生成的时needsRehash为true.
+func (p Params) Verify(password, encoded string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		var q Params
+		var version int
+		var salt, key []byte
+		if q, version, salt, key, err = parseArgon2(encoded); err != nil {
+			return false, false, err
+		}
+		if version != argon2.Version {
+			return false, false, fmt.Errorf("password: unsupported argon2 version %d", version)
+		}
+		actual := argon2.IDKey([]byte(password), salt, q.Time, q.Memory, q.Threads, uint32(len(key)))
+		ok = subtle.ConstantTimeCompare(actual, key) == 1
+		rehash := p.Algorithm != Argon2id || q.Memory != p.Memory || q.Time != p.Time || q.Threads != p.Threads
+		return ok, ok && rehash, nil
+	case strings.HasPrefix(encoded, "$2"):
+		err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return true, p.Algorithm != Bcrypt || cost != p.Cost, err
+	case len(encoded) == sha256.Size*2:
+		//旧版没有盐的sha256, 校验成功后总是需要升级.
+		legacy, err := hex.DecodeString(encoded)
+		if err != nil {
+			return false, false, ErrBadHash
+		}
+		sum := sha256.Sum256([]byte(password))
+		ok = subtle.ConstantTimeCompare(sum[:], legacy) == 1
+		return ok, ok, nil
+	}
+	return false, false, ErrBadHash
+}
+
+// parseArgon2 解析argon2id哈希, 返回其参数、版本、盐和哈希值.
+func parseArgon2(encoded string) (p Params, version int, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, 0, nil, nil, ErrBadHash
+	}
+	p.Algorithm = Argon2id
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, 0, nil, nil, ErrBadHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil ||
+		p.Memory == 0 || p.Time == 0 || p.Threads == 0 {
+		return p, 0, nil, nil, ErrBadHash
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, 0, nil, nil, ErrBadHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return p, 0, nil, nil, ErrBadHash
+	}
+	return p, version, salt, key, nil
+}
@@ -0,0 +1,52 @@
+package password
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestVerify 测试各种格式的哈希的校验以及是否需要升级.
+func TestVerify(t *testing.T) {
+	fast := Params{Algorithm: Argon2id, Memory: 64, Time: 1, Threads: 1}
+	argon, err := fast.Hash("1234")
+	if err != nil {
+		t.Fatalf("Hash failed. err:%v", err)
+	}
+	weak, _ := Params{Algorithm: Argon2id, Memory: 32, Time: 1, Threads: 1}.Hash("1234")
+	bcryptHash, err := Params{Algorithm: Bcrypt, Cost: bcrypt.MinCost}.Hash("1234")
+	if err != nil {
+		t.Fatalf("Hash failed. err:%v", err)
+	}
+	//sha256("1234"), 旧版没有盐的哈希.
+	legacy := "03ac674216f3e15c761ee1a5e255f067953623c8b388b4459e13f978d7c846f4"
+
+	var tests = []struct {
+		password string
+		hash     string
+		ok       bool
+		rehash   bool
+		bad      bool
+	}{
+		{"1234", argon, true, false, false},
+		{"12345", argon, false, false, false},
+		{"1234", weak, true, true, false},
+		{"1234", bcryptHash, true, true, false},
+		{"4321", bcryptHash, false, false, false},
+		{"1234", legacy, true, true, false},
+		{"4321", legacy, false, false, false},
+		{"1234", "$argon2id$v=19$m=64,t=1,p=0$c2FsdA$a2V5", false, false, true},
+		{"1234", "plaintext", false, false, true},
+	}
+	for _, test := range tests {
+		ok, rehash, err := fast.Verify(test.password, test.hash)
+		if ok != test.ok || rehash != test.rehash || (err != nil) != test.bad {
+			t.Errorf("Verify didn't pass. password:%s, hash:%s, ok:%t, rehash:%t, err:%v", test.password, test.hash, ok, rehash, err)
+		}
+	}
+
+	//同一个密码每次的哈希不同(随机的盐).
+	if again, _ := fast.Hash("1234"); again == argon {
+		t.Errorf("Hash didn't use salt. hash:%s", argon)
+	}
+}
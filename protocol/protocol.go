@@ -1,3 +1,5 @@
+//go:generate go run ../rpcgen -service User -o user_client.go
+
 package protocol
 
 // ReqSignUp 注册请求.
@@ -14,14 +16,31 @@ type RespSignUp struct {
 
 // ReqLogin 登录请求.
 type ReqLogin struct {
-	UserName string `json:"user_name"` // 用户名, 不为空
-	Password string `json:"password"`  // 密码, 不为空
+	UserName  string `json:"user_name"`  // 用户名, 不为空
+	Password  string `json:"password"`   // 密码, 不为空
+	UserAgent string `json:"user_agent"` // 登录设备的浏览器UA, 保存在会话中
+	IP        string `json:"ip"`         // 登录设备的IP, 保存在会话中
+	Refresh   bool   `json:"refresh"`    // 是否需要refresh token, 供不使用cookie的客户端(如json api)使用
 }
 
 // RespLogin 登录返回.
 type RespLogin struct {
-	Ret   int    `json:"ret"`   // 结果码 0:成功 1:用户名或密码错误 2:登录失败
-	Token string `json:"token"` // token
+	Ret          int    `json:"ret"`           // 结果码 0:成功 1:用户名或密码错误 2:登录失败
+	Token        string `json:"token"`         // token
+	RefreshToken string `json:"refresh_token"` // refresh token, 只在请求的Refresh为true时返回
+}
+
+// ReqRefreshToken 刷新token请求, 用refresh token换取新的token和refresh token.
+type ReqRefreshToken struct {
+	UserName     string `json:"user_name"`     // 用户名, 不为空
+	RefreshToken string `json:"refresh_token"` // 登录或上次刷新时返回的refresh token, 只能使用一次
+}
+
+// RespRefreshToken 刷新token返回.
+type RespRefreshToken struct {
+	Ret          int    `json:"ret"`           // 结果码 0:成功 1:refresh token无效或已过期 2:刷新失败
+	Token        string `json:"token"`         // 新的token
+	RefreshToken string `json:"refresh_token"` // 新的refresh token
 }
 
 // ReqGetProfile 获取信息请求.
@@ -61,3 +80,118 @@ type ReqUpdateNickName struct {
 type RespUpdateNickName struct {
 	Ret int `json:"ret"` // 结果码 0:成功 1:token校验失败 2:用户不存在 3:更新失败
 }
+
+// Session 用户的一个登录会话, 每次登录(每个设备)一个.
+type Session struct {
+	ID        string `json:"id"`         // 会话id, 用于退出指定的会话, 不能用来登录
+	CreatedAt int64  `json:"created_at"` // 登录时间(Unix秒)
+	LastSeen  int64  `json:"last_seen"`  // 最后一次使用的时间(Unix秒)
+	UserAgent string `json:"user_agent"` // 登录设备的浏览器UA
+	IP        string `json:"ip"`         // 登录设备的IP
+	Current   bool   `json:"current"`    // 是否是发起请求的会话
+}
+
+// ReqListSessions 获取会话列表请求.
+type ReqListSessions struct {
+	UserName string `json:"user_name"` // 用户名, 不为空
+	Token    string `json:"token"`     // token
+}
+
+// RespListSessions 获取会话列表返回.
+type RespListSessions struct {
+	Ret      int       `json:"ret"`      // 结果码 0:成功 1:token校验失败 3:获取失败
+	Sessions []Session `json:"sessions"` // 未过期的会话, 按最后使用时间从新到旧排序
+}
+
+// ReqRevokeSession 退出指定会话请求.
+type ReqRevokeSession struct {
+	UserName  string `json:"user_name"`  // 用户名, 不为空
+	Token     string `json:"token"`      // token
+	SessionID string `json:"session_id"` // 要退出的会话id
+}
+
+// RespRevokeSession 退出指定会话返回.
+type RespRevokeSession struct {
+	Ret int `json:"ret"` // 结果码 0:成功 1:token校验失败 2:会话不存在 3:退出失败
+}
+
+// ReqRevokeOtherSessions 退出当前会话以外的所有会话请求.
+type ReqRevokeOtherSessions struct {
+	UserName string `json:"user_name"` // 用户名, 不为空
+	Token    string `json:"token"`     // token, 它对应的会话保留
+}
+
+// RespRevokeOtherSessions 退出当前会话以外的所有会话返回.
+type RespRevokeOtherSessions struct {
+	Ret     int `json:"ret"`     // 结果码 0:成功 1:token校验失败 3:退出失败
+	Revoked int `json:"revoked"` // 退出的会话数
+}
+
+// ReqLogout 退出登录请求.
+type ReqLogout struct {
+	UserName string `json:"user_name"` // 用户名, 不为空
+	Token    string `json:"token"`     // token, 它对应的会话被删除
+}
+
+// RespLogout 退出登录返回.
+type RespLogout struct {
+	Ret int `json:"ret"` // 结果码 0:成功 1:token校验失败 3:退出失败
+}
+
+// 需要校验token的接口共用的结果码.
+const (
+	RetTokenInvalid = 1 // token校验失败.
+	RetFailed       = 3 // 获取或更新失败.
+)
+
+// AuthRequest 需要校验token的请求, 由tcp server的鉴权拦截器统一校验.
+type AuthRequest interface {
+	AuthInfo() (userName string, token string)
+}
+
+// RetSetter 可以设置结果码的返回, 鉴权失败时拦截器用它构造返回.
+type RetSetter interface {
+	SetRet(ret int)
+}
+
+// AuthInfo 实现AuthRequest.
+func (r ReqGetProfile) AuthInfo() (string, string) { return r.UserName, r.Token }
+
+// AuthInfo 实现AuthRequest.
+func (r ReqUpdateProfilePic) AuthInfo() (string, string) { return r.UserName, r.Token }
+
+// AuthInfo 实现AuthRequest.
+func (r ReqUpdateNickName) AuthInfo() (string, string) { return r.UserName, r.Token }
+
+// AuthInfo 实现AuthRequest.
+func (r ReqListSessions) AuthInfo() (string, string) { return r.UserName, r.Token }
+
+// AuthInfo 实现AuthRequest.
+func (r ReqRevokeSession) AuthInfo() (string, string) { return r.UserName, r.Token }
+
+// AuthInfo 实现AuthRequest.
+func (r ReqRevokeOtherSessions) AuthInfo() (string, string) { return r.UserName, r.Token }
+
+// AuthInfo 实现AuthRequest.
+func (r ReqLogout) AuthInfo() (string, string) { return r.UserName, r.Token }
+
+// SetRet 实现RetSetter.
+func (r *RespGetProfile) SetRet(ret int) { r.Ret = ret }
+
+// SetRet 实现RetSetter.
+func (r *RespUpdateProfilePic) SetRet(ret int) { r.Ret = ret }
+
+// SetRet 实现RetSetter.
+func (r *RespUpdateNickName) SetRet(ret int) { r.Ret = ret }
+
+// SetRet 实现RetSetter.
+func (r *RespListSessions) SetRet(ret int) { r.Ret = ret }
+
+// SetRet 实现RetSetter.
+func (r *RespRevokeSession) SetRet(ret int) { r.Ret = ret }
+
+// SetRet 实现RetSetter.
+func (r *RespRevokeOtherSessions) SetRet(ret int) { r.Ret = ret }
+
+// SetRet 实现RetSetter.
+func (r *RespLogout) SetRet(ret int) { r.Ret = ret }
@@ -0,0 +1,88 @@
+// Code generated by rpcgen. DO NOT EDIT.
+
+package protocol
+
+import (
+	"context"
+	"usermana/rpc"
+)
+
+// UserClient User服务带类型的客户端.
+type UserClient struct {
+	client *rpc.RPCClient
+}
+
+// NewUserClient 使用client调用User服务.
+func NewUserClient(client *rpc.RPCClient) *UserClient {
+	return &UserClient{client: client}
+}
+
+// GetProfile 调用User.GetProfile, 获取信息请求.
+func (c *UserClient) GetProfile(ctx context.Context, req ReqGetProfile) (RespGetProfile, error) {
+	var resp RespGetProfile
+	err := c.client.CallContext(ctx, "User.GetProfile", req, &resp)
+	return resp, err
+}
+
+// ListSessions 调用User.ListSessions, 获取会话列表请求.
+func (c *UserClient) ListSessions(ctx context.Context, req ReqListSessions) (RespListSessions, error) {
+	var resp RespListSessions
+	err := c.client.CallContext(ctx, "User.ListSessions", req, &resp)
+	return resp, err
+}
+
+// Login 调用User.Login, 登录请求.
+func (c *UserClient) Login(ctx context.Context, req ReqLogin) (RespLogin, error) {
+	var resp RespLogin
+	err := c.client.CallContext(ctx, "User.Login", req, &resp)
+	return resp, err
+}
+
+// Logout 调用User.Logout, 退出登录请求.
+func (c *UserClient) Logout(ctx context.Context, req ReqLogout) (RespLogout, error) {
+	var resp RespLogout
+	err := c.client.CallContext(ctx, "User.Logout", req, &resp)
+	return resp, err
+}
+
+// RefreshToken 调用User.RefreshToken, 刷新token请求, 用refresh token换取新的token和refresh token.
+func (c *UserClient) RefreshToken(ctx context.Context, req ReqRefreshToken) (RespRefreshToken, error) {
+	var resp RespRefreshToken
+	err := c.client.CallContext(ctx, "User.RefreshToken", req, &resp)
+	return resp, err
+}
+
+// RevokeOtherSessions 调用User.RevokeOtherSessions, 退出当前会话以外的所有会话请求.
+func (c *UserClient) RevokeOtherSessions(ctx context.Context, req ReqRevokeOtherSessions) (RespRevokeOtherSessions, error) {
+	var resp RespRevokeOtherSessions
+	err := c.client.CallContext(ctx, "User.RevokeOtherSessions", req, &resp)
+	return resp, err
+}
+
+// RevokeSession 调用User.RevokeSession, 退出指定会话请求.
+func (c *UserClient) RevokeSession(ctx context.Context, req ReqRevokeSession) (RespRevokeSession, error) {
+	var resp RespRevokeSession
+	err := c.client.CallContext(ctx, "User.RevokeSession", req, &resp)
+	return resp, err
+}
+
+// SignUp 调用User.SignUp, 注册请求.
+func (c *UserClient) SignUp(ctx context.Context, req ReqSignUp) (RespSignUp, error) {
+	var resp RespSignUp
+	err := c.client.CallContext(ctx, "User.SignUp", req, &resp)
+	return resp, err
+}
+
+// UpdateNickName 调用User.UpdateNickName, 更新用户昵称请求.
+func (c *UserClient) UpdateNickName(ctx context.Context, req ReqUpdateNickName) (RespUpdateNickName, error) {
+	var resp RespUpdateNickName
+	err := c.client.CallContext(ctx, "User.UpdateNickName", req, &resp)
+	return resp, err
+}
+
+// UpdateProfilePic 调用User.UpdateProfilePic, 更新用户头像请求.
+func (c *UserClient) UpdateProfilePic(ctx context.Context, req ReqUpdateProfilePic) (RespUpdateProfilePic, error) {
+	var resp RespUpdateProfilePic
+	err := c.client.CallContext(ctx, "User.UpdateProfilePic", req, &resp)
+	return resp, err
+}
@@ -1,7 +1,13 @@
 package redis
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
+	"sort"
+	"strconv"
 	"time"
 	"usermana/config"
 
@@ -30,8 +36,8 @@ func init() {
 }
 
 // GetProfile 获取用户信息.
-func GetProfile(userName string) (nickName string, picName string, hasData bool, err error) {
-	vals, err := client.HGetAll(client.Context(), userName).Result()
+func GetProfile(ctx context.Context, userName string) (nickName string, picName string, hasData bool, err error) {
+	vals, err := client.HGetAll(ctx, profileKey(userName)).Result()
 	if err != nil {
 		return "", "", false, err
 	}
@@ -43,13 +49,13 @@ func GetProfile(userName string) (nickName string, picName string, hasData bool,
 }
 
 // SetNickNameAndPicName 设置昵称和头像.
-func SetNickNameAndPicName(userName string, nickName string, picName string) error {
+func SetNickNameAndPicName(ctx context.Context, userName string, nickName string, picName string) error {
 	fields := map[string]interface{}{
 		"vaild":     "1",
 		"nick_name": nickName,
 		"pic_name":  picName,
 	}
-	err := client.HMSet(client.Context(), userName, fields).Err()
+	err := client.HMSet(ctx, profileKey(userName), fields).Err()
 	if err != nil {
 		return err
 	}
@@ -57,28 +63,325 @@ func SetNickNameAndPicName(userName string, nickName string, picName string) err
 }
 
 // InvaildCache 将用户数据设置无效，主要用于写入数据库之前，保持数据一直
-func InvaildCache(userName string) error {
-	err := client.HSet(client.Context(), userName, "vaild", "").Err()
+func InvaildCache(ctx context.Context, userName string) error {
+	err := client.HSet(ctx, profileKey(userName), "vaild", "").Err()
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// SetToken 设置token， 包括token的存活时间
-func SetToken(userName string, token string, expiration int64) error {
-	err := client.Set(client.Context(), "auth_"+userName, token, time.Duration(expiration*1e9)).Err()
+// Session 用户的一个登录会话. 每个会话保存在单独的key(session:{用户名}:会话id)中, 有各自的存活时间,
+// 用户的所有会话id保存在集合sessions:{用户名}中, 因此一个用户可以同时在多个设备上登录.
+type Session struct {
+	ID        string // 会话id, 由token的哈希生成, 可以展示给用户.
+	CreatedAt int64  // 登录时间(Unix秒).
+	LastSeen  int64  // 最后一次校验token的时间(Unix秒).
+	UserAgent string // 登录设备的浏览器UA.
+	IP        string // 登录设备的IP.
+}
+
+// Lifetime 会话的存活时间(秒).
+type Lifetime struct {
+	Idle    int64 // token的空闲时间, 超过Idle秒没有使用后失效, 每次校验成功后重新计时.
+	Max     int64 // token的最长存活时间, 从token生成时开始计算, 不随使用延长.
+	Refresh int64 // refresh token的存活时间, 从登录时开始计算, 刷新token时不延长.
+}
+
+// touchScript 更新会话的最后使用时间, 并将token的失效时间(deadline)延长到Idle秒后, 但不超过最长存活时间.
+// 会话的key在token和refresh token都失效后过期. 会话已过期时不做任何事(避免重新创建没有存活时间的key).
+// 失效时间(expires_at、deadline、refresh_expires_at)是Unix毫秒, 避免按整秒计算时延长的时间取决于在一秒中的位置.
+//
+//	KEYS[1]: 会话, KEYS[2]: 用户的会话id集合, ARGV[1]: 当前时间(Unix毫秒).
+var touchScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local now = tonumber(ARGV[1])
+local f = redis.call("HMGET", KEYS[1], "idle", "expires_at", "refresh_expires_at")
+local deadline = math.min(now + tonumber(f[1]) * 1000, tonumber(f[2]))
+redis.call("HSET", KEYS[1], "last_seen", string.format("%d", math.floor(now / 1000)), "deadline", string.format("%d", deadline))
+local ttl = math.max(deadline, tonumber(f[3])) - now
+redis.call("PEXPIRE", KEYS[1], string.format("%d", ttl))
+if redis.call("PTTL", KEYS[2]) < ttl then
+	redis.call("PEXPIRE", KEYS[2], string.format("%d", ttl))
+end
+return 1`)
+
+// extendScript 将KEYS[1]的存活时间延长到ARGV[1]毫秒, 已经更长时不变. 用于会话id集合, 集合在最后一个会话过期后才过期.
+var extendScript = redis.NewScript(`
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return 0`)
+
+// SetToken 为用户创建一个新的会话, 用户已有的会话不受影响. refreshToken为空时不使用refresh token.
+// s中的UserAgent和IP保存在会话中, 其余字段由SetToken设置.
+// redis中只保存token和refresh token的哈希, 泄漏的redis数据不能直接用来登录.
+func SetToken(ctx context.Context, userName string, token string, refreshToken string, s Session, l Lifetime) error {
+	now := time.Now()
+	f := sessionFields{createdAt: now.Unix(), userAgent: s.UserAgent, ip: s.IP, idle: l.Idle, lifetime: l.Max}
+	if refreshToken != "" {
+		f.refreshExpiresAt = unixMilli(now) + l.Refresh*1000
+	}
+	return createSession(ctx, userName, token, refreshToken, f)
+}
+
+// CheckToken 校验token并更新会话的最后使用时间, 延长token的失效时间, 比较的耗时与token是否正确无关.
+// token对应的会话不存在(未登录、已过期或已退出)或token已失效时返回false.
+func CheckToken(ctx context.Context, userName string, token string) (bool, error) {
+	key := sessionKey(userName, SessionID(token))
+	vals, err := client.HMGet(ctx, key, "token", "deadline").Result()
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
+	val, _ := vals[0].(string)
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(val)) != 1 {
+		return false, nil
+	}
+	now := unixMilli(time.Now())
+	deadline, _ := vals[1].(string)
+	if d, err := strconv.ParseInt(deadline, 10, 64); err != nil || now >= d {
+		return false, nil
+	}
+	n, err := touchScript.Run(ctx, client, []string{key, sessionsKey(userName)}, now).Int()
+	return n == 1, err
 }
 
-// CheckToken 校验token
-func CheckToken(userName string, token string) (bool, error) {
-	val, err := client.Get(client.Context(), "auth_"+userName).Result()
+// RefreshToken 用refreshToken换取新的token, 成功时旧的token和refreshToken失效, 会话换成token和newRefreshToken.
+// 新token的最长存活时间重新计算, newRefreshToken的失效时间与refreshToken相同.
+// refreshToken不存在、已过期或已经被使用过时返回false.
+func RefreshToken(ctx context.Context, userName string, refreshToken string, token string, newRefreshToken string) (bool, error) {
+	ids, sessions, err := loadSessions(ctx, userName)
 	if err != nil {
 		return false, err
 	}
-	return token == val, nil
+	now := unixMilli(time.Now())
+	hash := []byte(hashToken(refreshToken))
+	for i, vals := range sessions {
+		if subtle.ConstantTimeCompare(hash, []byte(vals["refresh"])) != 1 {
+			continue
+		}
+		if expiresAt, _ := strconv.ParseInt(vals["refresh_expires_at"], 10, 64); now >= expiresAt {
+			return false, nil
+		}
+		//只有删除了旧会话的请求才能创建新会话, 同一个refreshToken只能使用一次.
+		if ok, err := DeleteSession(ctx, userName, ids[i]); err != nil || !ok {
+			return false, err
+		}
+		f := sessionFields{userAgent: vals["user_agent"], ip: vals["ip"]}
+		f.createdAt, _ = strconv.ParseInt(vals["created_at"], 10, 64)
+		f.idle, _ = strconv.ParseInt(vals["idle"], 10, 64)
+		f.lifetime, _ = strconv.ParseInt(vals["lifetime"], 10, 64)
+		f.refreshExpiresAt, _ = strconv.ParseInt(vals["refresh_expires_at"], 10, 64)
+		return true, createSession(ctx, userName, token, newRefreshToken, f)
+	}
+	return false, nil
+}
+
+// unixMilli 返回t的Unix毫秒.
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// sessionFields 会话中登录时确定的字段, 刷新token时保留.
+type sessionFields struct {
+	createdAt        int64
+	userAgent        string
+	ip               string
+	idle             int64 // Lifetime.Idle(秒).
+	lifetime         int64 // Lifetime.Max(秒).
+	refreshExpiresAt int64 // refresh token的失效时间(Unix毫秒), 没有refresh token时为0.
+}
+
+// createSession 保存token的会话, token的失效时间由当前时间和f计算.
+func createSession(ctx context.Context, userName string, token string, refreshToken string, f sessionFields) error {
+	now := unixMilli(time.Now())
+	expiresAt := now + f.lifetime*1000
+	deadline := now + f.idle*1000
+	if deadline > expiresAt {
+		deadline = expiresAt
+	}
+	//会话的key在token和refresh token都失效后过期.
+	ttl := deadline
+	if f.refreshExpiresAt > ttl {
+		ttl = f.refreshExpiresAt
+	}
+	ttl -= now
+	refresh := ""
+	if refreshToken != "" {
+		refresh = hashToken(refreshToken)
+	}
+
+	id := SessionID(token)
+	pipe := client.TxPipeline()
+	pipe.HSet(ctx, sessionKey(userName, id), map[string]interface{}{
+		"token":              hashToken(token),
+		"refresh":            refresh,
+		"created_at":         f.createdAt,
+		"last_seen":          now / 1000,
+		"user_agent":         f.userAgent,
+		"ip":                 f.ip,
+		"idle":               f.idle,
+		"lifetime":           f.lifetime,
+		"expires_at":         expiresAt,
+		"deadline":           deadline,
+		"refresh_expires_at": f.refreshExpiresAt,
+	})
+	pipe.PExpire(ctx, sessionKey(userName, id), time.Duration(ttl)*time.Millisecond)
+	pipe.SAdd(ctx, sessionsKey(userName), id)
+	extendScript.Eval(ctx, pipe, []string{sessionsKey(userName)}, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ListSessions 返回用户未过期的会话, 按最后使用时间从新到旧排序, 时间相同时按登录时间从新到旧、会话id排序.
+func ListSessions(ctx context.Context, userName string) ([]Session, error) {
+	ids, vals, err := loadSessions(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []Session
+	for i, v := range vals {
+		s := Session{ID: ids[i], UserAgent: v["user_agent"], IP: v["ip"]}
+		s.CreatedAt, _ = strconv.ParseInt(v["created_at"], 10, 64)
+		s.LastSeen, _ = strconv.ParseInt(v["last_seen"], 10, 64)
+		sessions = append(sessions, s)
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		a, b := sessions[i], sessions[j]
+		if a.LastSeen != b.LastSeen {
+			return a.LastSeen > b.LastSeen
+		}
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt > b.CreatedAt
+		}
+		return a.ID < b.ID
+	})
+	return sessions, nil
+}
+
+// loadSessions 返回用户未过期的会话id及会话的所有字段. 同时从集合中删除已过期的会话id.
+func loadSessions(ctx context.Context, userName string) ([]string, []map[string]string, error) {
+	ids, err := client.SMembers(ctx, sessionsKey(userName)).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, nil, err
+	}
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, sessionKey(userName, id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	var live []string
+	var sessions []map[string]string
+	var expired []interface{}
+	for i, cmd := range cmds {
+		vals := cmd.Val()
+		if vals["token"] == "" {
+			expired = append(expired, ids[i])
+			continue
+		}
+		live = append(live, ids[i])
+		sessions = append(sessions, vals)
+	}
+	if len(expired) > 0 {
+		if err := client.SRem(ctx, sessionsKey(userName), expired...).Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return live, sessions, nil
+}
+
+// DeleteSession 删除用户的会话id, 会话不存在或id不是合法的会话id时返回false.
+func DeleteSession(ctx context.Context, userName string, id string) (bool, error) {
+	if !ValidSessionID(id) {
+		return false, nil
+	}
+	pipe := client.TxPipeline()
+	del := pipe.Del(ctx, sessionKey(userName, id))
+	pipe.SRem(ctx, sessionsKey(userName), id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return del.Val() == 1, nil
+}
+
+// DeleteOtherSessions 删除用户除keepID以外的所有会话, 返回删除的会话数.
+func DeleteOtherSessions(ctx context.Context, userName string, keepID string) (int, error) {
+	ids, err := client.SMembers(ctx, sessionsKey(userName)).Result()
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	var members []interface{}
+	for _, id := range ids {
+		if id != keepID {
+			keys = append(keys, sessionKey(userName, id))
+			members = append(members, id)
+		}
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	pipe := client.TxPipeline()
+	del := pipe.Del(ctx, keys...)
+	pipe.SRem(ctx, sessionsKey(userName), members...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(del.Val()), nil
+}
+
+// DeleteAllSessions 删除用户的所有会话, 返回删除的会话数. 删除后用户所有的token立即失效.
+func DeleteAllSessions(ctx context.Context, userName string) (int, error) {
+	return DeleteOtherSessions(ctx, userName, "")
+}
+
+// SessionID 返回token对应的会话id, 即token哈希的前16位. 会话id不能反推出token.
+func SessionID(token string) string {
+	return hashToken(token)[:16]
+}
+
+// ValidSessionID 判断id是否是SessionID生成的会话id(16位小写十六进制). 会话id会拼接到key中,
+// 来自客户端的id必须先校验, 否则可以构造出其他用户的key.
+func ValidSessionID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// profileKey 返回缓存用户信息的key. 所有的key都带有前缀, 用户名不能构造出其他用途的key.
+func profileKey(userName string) string {
+	return "profile:" + userName
+}
+
+// sessionKey 返回保存会话的key. 会话id的长度固定, 因此不同用户的key不会相同.
+func sessionKey(userName, id string) string {
+	return "session:{" + userName + "}:" + id
+}
+
+// sessionsKey 返回保存用户所有会话id的集合的key.
+func sessionsKey(userName string) string {
+	return "sessions:{" + userName + "}"
+}
+
+// hashToken 返回token的sha256. token本身是高熵的随机数, 不需要加盐或使用慢哈希.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Close 关闭redis连接池, 在程序退出前调用.
+func Close() error {
+	return client.Close()
 }
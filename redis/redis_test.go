@@ -1,10 +1,13 @@
 package redis
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestSetNickNameAndPicName 测试SetNickNameAndPicName函数.
@@ -15,7 +18,7 @@ func TestSetNickNameAndPicName(t *testing.T) {
 		{"bot1", "soy1234", ""},
 	}
 	for _, test := range tests {
-		if err := SetNickNameAndPicName(test.userName, test.nickName, test.picName); err != nil {
+		if err := SetNickNameAndPicName(context.Background(), test.userName, test.nickName, test.picName); err != nil {
 			t.Errorf("SetNickNameAndPicName didn't pass. userName:%s, nickName:%s, picName:%s, err:%q", test.userName, test.nickName, test.picName, err)
 		}
 	}
@@ -32,7 +35,7 @@ func TestGetProfile(t *testing.T) {
 		{"", false},
 	}
 	for _, test := range tests {
-		if _, _, ok, err := GetProfile(test.userName); err != nil || ok != test.hasData {
+		if _, _, ok, err := GetProfile(context.Background(), test.userName); err != nil || ok != test.hasData {
 			fmt.Printf("ok = %t, err = %q\n", ok, err)
 			t.Errorf("GetProfile didn't pass. userName:%s, hasdata:%t, err:%q", test.userName, test.hasData, err)
 		}
@@ -47,7 +50,7 @@ func TestInvaildCache(t *testing.T) {
 		{"bot2"},
 	}
 	for _, test := range tests {
-		if err := InvaildCache(test.userName); err != nil {
+		if err := InvaildCache(context.Background(), test.userName); err != nil {
 			t.Errorf("InvaildCache didn't pass. userName:%s, err:%q", test.userName, err)
 		}
 	}
@@ -61,9 +64,11 @@ func TestSetToken(t *testing.T) {
 		exp      int64
 	}{
 		{"bot2", "auth", 5},
+		{"bot2", "auth3", 5},
 	}
 	for _, test := range tests {
-		if err := SetToken(test.userName, test.token, test.exp); err != nil {
+		l := Lifetime{Idle: test.exp, Max: test.exp}
+		if err := SetToken(context.Background(), test.userName, test.token, "", Session{UserAgent: "test", IP: "127.0.0.1"}, l); err != nil {
 			t.Errorf("SetToken didn't pass. userName:%s, token:%s, exp:%d, err:%q", test.userName, test.token, test.exp, err)
 		}
 	}
@@ -77,15 +82,128 @@ func TestCheckToken(t *testing.T) {
 		ok       bool
 	}{
 		{"bot2", "auth", true},
+		{"bot2", "auth3", true},
 		{"bot2", "auth2", false},
+		{"botNotLogin", "auth", false},
+		{"bot2", "", false},
 	}
 	for _, test := range tests {
-		if ok, err := CheckToken(test.userName, test.token); err != nil || ok != test.ok {
+		if ok, err := CheckToken(context.Background(), test.userName, test.token); err != nil || ok != test.ok {
 			t.Errorf("CheckToken didn't pass. userName:%s, token:%s, ok:%t, err:%q", test.userName, test.token, test.ok, err)
 		}
 	}
 }
 
+// TestProfileKey 测试用户信息缓存和会话使用不同的key, 用户名与其他用户的会话集合相同时不影响其登录.
+func TestProfileKey(t *testing.T) {
+	ctx := context.Background()
+	if err := SetNickNameAndPicName(ctx, sessionsKey("botVictim"), "nick", ""); err != nil {
+		t.Fatalf("SetNickNameAndPicName didn't pass. err:%q", err)
+	}
+	if err := SetToken(ctx, "botVictim", "victim", "", Session{}, Lifetime{Idle: 5, Max: 5}); err != nil {
+		t.Errorf("SetToken didn't pass. err:%q", err)
+	}
+	if ok, err := CheckToken(ctx, "botVictim", "victim"); err != nil || !ok {
+		t.Errorf("CheckToken didn't pass. ok:%t, err:%q", ok, err)
+	}
+}
+
+// TestSessions 测试ListSessions、DeleteSession和DeleteOtherSessions函数.
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	for _, token := range []string{"s1", "s2", "s3"} {
+		if err := SetToken(ctx, "botSessions", token, "", Session{UserAgent: "ua-" + token}, Lifetime{Idle: 5, Max: 5}); err != nil {
+			t.Fatalf("SetToken didn't pass. token:%s, err:%q", token, err)
+		}
+	}
+	if sessions, err := ListSessions(ctx, "botSessions"); err != nil || len(sessions) != 3 {
+		t.Errorf("ListSessions didn't pass. want:3 sessions, sessions:%v, err:%q", sessions, err)
+	}
+	if ok, err := DeleteSession(ctx, "botSessions", SessionID("s1")); err != nil || !ok {
+		t.Errorf("DeleteSession didn't pass. ok:%t, err:%q", ok, err)
+	}
+	if ok, err := DeleteSession(ctx, "botSessions", SessionID("s1")); err != nil || ok {
+		t.Errorf("DeleteSession didn't pass. deleted twice, ok:%t, err:%q", ok, err)
+	}
+	//不合法的会话id不能删除其他用户(botSessions_x)的会话.
+	if err := SetToken(ctx, "botSessions_x", "s4", "", Session{}, Lifetime{Idle: 5, Max: 5}); err != nil {
+		t.Fatalf("SetToken didn't pass. err:%q", err)
+	}
+	for _, id := range []string{"", "x_" + SessionID("s4"), "}:" + SessionID("s4"), strings.ToUpper(SessionID("s2")), SessionID("s2") + "0"} {
+		if ok, err := DeleteSession(ctx, "botSessions", id); err != nil || ok {
+			t.Errorf("DeleteSession didn't pass. invalid id:%s, ok:%t, err:%q", id, ok, err)
+		}
+	}
+	if ok, err := CheckToken(ctx, "botSessions_x", "s4"); err != nil || !ok {
+		t.Errorf("CheckToken didn't pass. session of another user was deleted, ok:%t, err:%q", ok, err)
+	}
+	if n, err := DeleteOtherSessions(ctx, "botSessions", SessionID("s3")); err != nil || n != 1 {
+		t.Errorf("DeleteOtherSessions didn't pass. want:1, n:%d, err:%q", n, err)
+	}
+	sessions, err := ListSessions(ctx, "botSessions")
+	if err != nil || len(sessions) != 1 || sessions[0].ID != SessionID("s3") || sessions[0].UserAgent != "ua-s3" {
+		t.Errorf("ListSessions didn't pass. want:s3, sessions:%v, err:%q", sessions, err)
+	}
+	for _, token := range []string{"s1", "s2"} {
+		if ok, err := CheckToken(ctx, "botSessions", token); err != nil || ok {
+			t.Errorf("CheckToken didn't pass. revoked token:%s, ok:%t, err:%q", token, ok, err)
+		}
+	}
+}
+
+// TestSlidingExpiry 测试CheckToken延长token的失效时间, 但不超过最长存活时间.
+// 失效时间精确到毫秒, 每次校验距离失效时间都有500ms以上的余量.
+func TestSlidingExpiry(t *testing.T) {
+	ctx := context.Background()
+	if err := SetToken(ctx, "botSliding", "slide", "", Session{}, Lifetime{Idle: 2, Max: 4}); err != nil {
+		t.Fatalf("SetToken didn't pass. err:%q", err)
+	}
+	var tests = []struct {
+		sleep time.Duration
+		ok    bool
+	}{
+		{1500 * time.Millisecond, true},  //空闲时间内使用, 失效时间延长.
+		{1500 * time.Millisecond, true},  //超过了登录后的空闲时间, 但在延长后的失效时间内.
+		{1500 * time.Millisecond, false}, //超过了最长存活时间.
+	}
+	for i, test := range tests {
+		time.Sleep(test.sleep)
+		if ok, err := CheckToken(ctx, "botSliding", "slide"); err != nil || ok != test.ok {
+			t.Errorf("CheckToken didn't pass. step:%d, want:%t, ok:%t, err:%q", i, test.ok, ok, err)
+		}
+	}
+}
+
+// TestRefreshToken 测试RefreshToken函数, refresh token只能使用一次, 使用后旧的token失效.
+func TestRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	if err := SetToken(ctx, "botRefresh", "access1", "refresh1", Session{UserAgent: "ua"}, Lifetime{Idle: 5, Max: 5, Refresh: 10}); err != nil {
+		t.Fatalf("SetToken didn't pass. err:%q", err)
+	}
+	var tests = []struct {
+		refreshToken, token, newRefreshToken string
+		ok                                   bool
+	}{
+		{"refresh1", "access2", "refresh2", true},
+		{"refresh1", "access3", "refresh3", false},
+		{"access2", "access3", "refresh3", false},
+		{"refresh2", "access3", "refresh3", true},
+	}
+	for _, test := range tests {
+		if ok, err := RefreshToken(ctx, "botRefresh", test.refreshToken, test.token, test.newRefreshToken); err != nil || ok != test.ok {
+			t.Errorf("RefreshToken didn't pass. refreshToken:%s, want:%t, ok:%t, err:%q", test.refreshToken, test.ok, ok, err)
+		}
+	}
+	for token, want := range map[string]bool{"access1": false, "access2": false, "access3": true} {
+		if ok, err := CheckToken(ctx, "botRefresh", token); err != nil || ok != want {
+			t.Errorf("CheckToken didn't pass. token:%s, want:%t, ok:%t, err:%q", token, want, ok, err)
+		}
+	}
+	if sessions, err := ListSessions(ctx, "botRefresh"); err != nil || len(sessions) != 1 || sessions[0].UserAgent != "ua" {
+		t.Errorf("ListSessions didn't pass. want:1 session, sessions:%v, err:%q", sessions, err)
+	}
+}
+
 //BenchmarkSetTokenSame 基准测试SetToken函数(相同的用户名).
 func BenchmarkSetTokenSame(b *testing.B) {
 	// b.ReportAllocs()
@@ -98,7 +216,7 @@ func BenchmarkSetTokenSame(b *testing.B) {
 	}
 	for _, test := range tests {
 		for i := 0; i < b.N; i++ {
-			if err := SetToken(test.userName, test.token, test.exp); err != nil {
+			if err := SetToken(context.Background(), test.userName, test.token, "", Session{}, Lifetime{Idle: test.exp, Max: test.exp}); err != nil {
 				b.Errorf("SetToken didn't pass. userName:%s, token:%s, exp:%d, err:%q", test.userName, test.token, test.exp, err)
 			}
 		}
@@ -108,7 +226,7 @@ func BenchmarkSetTokenSame(b *testing.B) {
 //BenchmarkSetTokenRandom 基准测试SetTokenRandom函数(用户名随机).
 func BenchmarkSetTokenRandom(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		if err := SetToken("bot"+strconv.Itoa(rand.Intn(10000000)), "auth", 5); err != nil {
+		if err := SetToken(context.Background(), "bot"+strconv.Itoa(rand.Intn(10000000)), "auth", "", Session{}, Lifetime{Idle: 5, Max: 5}); err != nil {
 			b.Errorf("SetToken didn't pass")
 		}
 	}
@@ -0,0 +1,34 @@
+package rpc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// schemes 地址前缀对应的network.
+var schemes = map[string]string{
+	"tcp":  "tcp",
+	"tcp4": "tcp4",
+	"tcp6": "tcp6",
+	"unix": "unix",
+}
+
+// ParseAddr 解析客户端和服务端使用的地址, 返回net.Dial和net.Listen使用的network和地址.
+// 地址的形式为scheme://addr, eg:
+//
+//	tcp://example.com:3194     IPv4或IPv6
+//	tcp6://[::1]:3194          只使用IPv6
+//	unix:///tmp/usermana.sock  同一台机器上使用Unix domain socket
+//
+// 没有scheme时与之前相同, 使用tcp4.
+func ParseAddr(address string) (network, addr string, err error) {
+	i := strings.Index(address, "://")
+	if i < 0 {
+		return "tcp4", address, nil
+	}
+	network, ok := schemes[address[:i]]
+	if !ok {
+		return "", "", fmt.Errorf("rpc: unknown scheme in address %q", address)
+	}
+	return network, address[i+len("://"):], nil
+}
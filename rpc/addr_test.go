@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseAddr 测试解析带scheme的地址.
+func TestParseAddr(t *testing.T) {
+	var tests = []struct {
+		address string
+		network string
+		addr    string
+		ok      bool
+	}{
+		{":3194", "tcp4", ":3194", true},
+		{"127.0.0.1:3194", "tcp4", "127.0.0.1:3194", true},
+		{"tcp://example.com:3194", "tcp", "example.com:3194", true},
+		{"tcp6://[::1]:3194", "tcp6", "[::1]:3194", true},
+		{"unix:///tmp/usermana.sock", "unix", "/tmp/usermana.sock", true},
+		{"udp://127.0.0.1:3194", "", "", false},
+	}
+	for _, test := range tests {
+		network, addr, err := ParseAddr(test.address)
+		if network != test.network || addr != test.addr || (err == nil) != test.ok {
+			t.Errorf("ParseAddr didn't pass. address:%s, network:%s, addr:%s, err:%v", test.address, network, addr, err)
+		}
+	}
+}
+
+// TestTransport 测试通过各种地址监听和调用.
+func TestTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpc")
+	if err != nil {
+		t.Fatalf("TempDir failed. err:%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tests = []string{
+		"127.0.0.1:0",
+		"tcp://127.0.0.1:0",
+		"tcp6://[::1]:0",
+		"unix://" + filepath.Join(dir, "usermana.sock"),
+	}
+	for _, address := range tests {
+		server := newTestServer(t)
+		listener, err := server.listen(address)
+		if err != nil {
+			if strings.HasPrefix(address, "tcp6") {
+				//测试环境可能不支持IPv6.
+				t.Logf("listen %s failed, skipped. err:%v", address, err)
+				continue
+			}
+			t.Fatalf("listen failed. address:%s, err:%v", address, err)
+		}
+		go server.accept(listener)
+
+		//使用监听到的实际地址(端口), 保留scheme.
+		clientAddr := listener.Addr().String()
+		if i := strings.Index(address, "://"); i >= 0 {
+			clientAddr = address[:i+len("://")] + clientAddr
+		}
+		client, err := Client(1, clientAddr)
+		if err != nil {
+			t.Fatalf("Client failed. address:%s, err:%v", clientAddr, err)
+		}
+		var resp echoResp
+		if err := client.Call("Greeter.Hello", echoReq{Msg: "bot1"}, &resp); err != nil || resp.Msg != "hello bot1" {
+			t.Errorf("transport didn't pass. address:%s, resp:%v, err:%v", clientAddr, resp, err)
+		}
+		client.Close()
+		listener.Close()
+	}
+}
@@ -0,0 +1,212 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"hash/crc32"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// BalancePolicy 客户端在多个后端之间选择的策略.
+type BalancePolicy int
+
+// 负载均衡策略.
+const (
+	RoundRobin       BalancePolicy = iota // 轮询.
+	LeastOutstanding                      // 选择正在进行的请求最少的后端.
+	ConsistentHash                        // 按WithHashKey设置的key(如用户名)一致性哈希, 没有key时轮询.
+)
+
+const (
+	// ringReplicas 一致性哈希中每个后端的虚拟节点数.
+	ringReplicas = 100
+	// tlsHandshakeTimeout 客户端和服务端TLS握手的超时时间.
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+// hashKey context中保存哈希key的键.
+type hashKey struct{}
+
+// WithHashKey 返回带有哈希key的ctx, 使用ConsistentHash时相同key的请求总是发往同一个后端(该后端不可用时顺延到下一个).
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// backend 一个rpc服务端, 包含若干条到该服务端的连接.
+type backend struct {
+	outstanding int64  // 正在进行的请求数, 原子读写, 放在首位保证64位对齐.
+	next        uint32 // 轮询选择连接的计数.
+	addr        string
+	conns       []*clientConn
+}
+
+// dialBackend 创建connections个到addr(见ParseAddr)的连接, 每个连接启动读协程和心跳协程.
+func dialBackend(addr string, connections int, opts *options) (*backend, error) {
+	network, address, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := opts.tlsConfig
+	if tlsConfig != nil && tlsConfig.ServerName == "" {
+		//没有指定时使用地址中的host校验服务端证书.
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(address)
+	}
+	dial := func() (net.Conn, error) {
+		//laddr 本地地址默认.
+		conn, err := net.Dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			return conn, nil
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+	return newBackend(addr, dial, connections, opts)
+}
+
+// newBackend 使用dial创建connections个连接.
+func newBackend(addr string, dial func() (net.Conn, error), connections int, opts *options) (*backend, error) {
+	b := &backend{addr: addr}
+	for i := 0; i < connections; i++ {
+		cc, err := newClientConn(dial, opts)
+		if err != nil {
+			b.close()
+			return nil, err
+		}
+		b.conns = append(b.conns, cc)
+	}
+	return b, nil
+}
+
+// healthy 返回后端是否有可用的连接, 没有可用连接的后端不参与负载均衡.
+func (b *backend) healthy() bool {
+	for _, cc := range b.conns {
+		if cc.getState() == stateLive {
+			return true
+		}
+	}
+	return false
+}
+
+// getConn 轮询选择一个连接, 跳过正在重连的连接. 连接可以被多个请求同时使用，因此不需要归还.
+// 所有连接都不可用时返回其中一个, 请求会以Unavailable失败.
+func (b *backend) getConn() *clientConn {
+	n := atomic.AddUint32(&b.next, 1)
+	for i := uint32(0); i < uint32(len(b.conns)); i++ {
+		cc := b.conns[(n+i)%uint32(len(b.conns))]
+		if cc.getState() == stateLive {
+			return cc
+		}
+	}
+	return b.conns[n%uint32(len(b.conns))]
+}
+
+// health 返回后端各状态连接的数量.
+func (b *backend) health() PoolHealth {
+	var h PoolHealth
+	for _, cc := range b.conns {
+		switch cc.getState() {
+		case stateLive:
+			h.Live++
+		case stateRedialing:
+			h.Redialing++
+		case stateDraining:
+			h.Draining++
+		default:
+			h.Dead++
+		}
+	}
+	return h
+}
+
+// close 关闭后端的所有连接.
+func (b *backend) close() {
+	for _, cc := range b.conns {
+		cc.close()
+	}
+}
+
+// hashRing 一致性哈希环.
+type hashRing struct {
+	hashes   []uint32   // 虚拟节点的哈希值, 升序.
+	backends []*backend // 与hashes对应的后端.
+}
+
+// newHashRing 为backends创建哈希环, 每个后端有ringReplicas个虚拟节点.
+func newHashRing(backends []*backend) *hashRing {
+	type node struct {
+		hash uint32
+		b    *backend
+	}
+	nodes := make([]node, 0, len(backends)*ringReplicas)
+	for _, b := range backends {
+		for i := 0; i < ringReplicas; i++ {
+			nodes = append(nodes, node{crc32.ChecksumIEEE([]byte(b.addr + "#" + strconv.Itoa(i))), b})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].hash < nodes[j].hash })
+
+	ring := &hashRing{hashes: make([]uint32, len(nodes)), backends: make([]*backend, len(nodes))}
+	for i, n := range nodes {
+		ring.hashes[i], ring.backends[i] = n.hash, n.b
+	}
+	return ring
+}
+
+// get 返回key在哈希环上顺时针方向第一个健康的后端, 都不健康时返回nil.
+func (h *hashRing) get(key string) *backend {
+	if len(h.hashes) == 0 {
+		return nil
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(h.hashes), func(i int) bool { return h.hashes[i] >= hash })
+	for i := 0; i < len(h.hashes); i++ {
+		if b := h.backends[(start+i)%len(h.hashes)]; b.healthy() {
+			return b
+		}
+	}
+	return nil
+}
+
+// pick 按策略选择一个健康的后端. 都不健康时返回其中一个, 请求会以Unavailable失败.
+func pick(ctx context.Context, policy BalancePolicy, backends []*backend, ring *hashRing, n uint32) *backend {
+	if policy == ConsistentHash {
+		if key, ok := ctx.Value(hashKey{}).(string); ok {
+			if b := ring.get(key); b != nil {
+				return b
+			}
+		}
+	}
+
+	var picked *backend
+	for i := uint32(0); i < uint32(len(backends)); i++ {
+		b := backends[(n+i)%uint32(len(backends))]
+		if !b.healthy() {
+			continue
+		}
+		if policy != LeastOutstanding {
+			return b
+		}
+		//从轮询的位置开始比较, 请求数相同时不会总是选中第一个后端.
+		if picked == nil || atomic.LoadInt64(&b.outstanding) < atomic.LoadInt64(&picked.outstanding) {
+			picked = b
+		}
+	}
+	if picked == nil {
+		picked = backends[n%uint32(len(backends))]
+	}
+	return picked
+}
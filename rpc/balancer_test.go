@@ -0,0 +1,203 @@
+package rpc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startBackends 启动n个rpc服务端, 返回它们的地址、服务端, 以及获取各服务端已处理请求数的函数.
+func startBackends(t *testing.T, n int) ([]string, []*RPCServer, func() []int) {
+	var mu sync.Mutex
+	served := make([]int, n)
+	addrs := make([]string, n)
+	servers := make([]*RPCServer, n)
+	for i := 0; i < n; i++ {
+		i := i
+		servers[i] = newTestServer(t)
+		servers[i].Use(func(ctx context.Context, info *ServerInfo, req interface{}, next Handler) (interface{}, error) {
+			mu.Lock()
+			served[i]++
+			mu.Unlock()
+			return next(ctx, req)
+		})
+		addrs[i] = listen(t, servers[i])
+	}
+	return addrs, servers, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), served...)
+	}
+}
+
+// TestBalance 测试各负载均衡策略在后端之间的分布.
+func TestBalance(t *testing.T) {
+	var tests = []struct {
+		name   string
+		policy BalancePolicy
+		key    string
+		served func([]int) bool
+	}{
+		{"round robin", RoundRobin, "", func(served []int) bool { return reflect.DeepEqual(served, []int{3, 3, 3}) }},
+		{"least outstanding", LeastOutstanding, "", func(served []int) bool { return reflect.DeepEqual(served, []int{3, 3, 3}) }},
+		{"consistent hash", ConsistentHash, "bot1", func(served []int) bool {
+			count := 0
+			for _, n := range served {
+				if n != 0 {
+					count++
+				}
+			}
+			return count == 1
+		}},
+	}
+	for _, test := range tests {
+		addrs, _, served := startBackends(t, 3)
+		client, err := ResolverClient(1, StaticResolver(addrs), LoadBalance(test.policy))
+		if err != nil {
+			t.Fatalf("ResolverClient failed. err:%v", err)
+		}
+		ctx := context.Background()
+		if test.key != "" {
+			ctx = WithHashKey(ctx, test.key)
+		}
+		for i := 0; i < 9; i++ {
+			var resp echoResp
+			if err := client.CallContext(ctx, "Echo", echoReq{Msg: "hello"}, &resp); err != nil {
+				t.Errorf("Call didn't pass. name:%s, err:%v", test.name, err)
+			}
+		}
+		if !test.served(served()) {
+			t.Errorf("balance didn't pass. name:%s, served:%v", test.name, served())
+		}
+		client.Close()
+	}
+}
+
+// TestLeastOutstanding 测试请求不会发往正在处理慢请求的后端.
+func TestLeastOutstanding(t *testing.T) {
+	addrs, _, served := startBackends(t, 2)
+	client, err := ResolverClient(1, StaticResolver(addrs), LoadBalance(LeastOutstanding))
+	if err != nil {
+		t.Fatalf("ResolverClient failed. err:%v", err)
+	}
+	defer client.Close()
+
+	slow := make(chan error, 1)
+	go func() {
+		var resp echoResp
+		slow <- client.Call("Echo", echoReq{Msg: "hello", Sleep: 200}, &resp)
+	}()
+	for n := served(); n[0]+n[1] == 0; n = served() {
+		time.Sleep(time.Millisecond)
+	}
+	busy := 0
+	if served()[1] == 1 {
+		busy = 1
+	}
+	for i := 0; i < 4; i++ {
+		var resp echoResp
+		if err := client.Call("Echo", echoReq{Msg: "hello"}, &resp); err != nil {
+			t.Errorf("Call didn't pass. err:%v", err)
+		}
+	}
+	if n := served(); n[busy] != 1 || n[1-busy] != 4 {
+		t.Errorf("least outstanding didn't pass. served:%v", n)
+	}
+	if err := <-slow; err != nil {
+		t.Errorf("slow Call didn't pass. err:%v", err)
+	}
+}
+
+// TestUnhealthyBackend 测试关闭的后端不再参与负载均衡.
+func TestUnhealthyBackend(t *testing.T) {
+	addrs, servers, served := startBackends(t, 3)
+	client, err := ResolverClient(2, StaticResolver(addrs), RedialBackoff(time.Second, time.Second))
+	if err != nil {
+		t.Fatalf("ResolverClient failed. err:%v", err)
+	}
+	defer client.Close()
+
+	if err := servers[1].Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed. err:%v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for client.BackendHealth()[addrs[1]].Live != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("backend health didn't pass. health:%v", client.BackendHealth())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 6; i++ {
+		var resp echoResp
+		if err := client.Call("Echo", echoReq{Msg: "hello"}, &resp); err != nil {
+			t.Errorf("Call didn't pass. err:%v", err)
+		}
+	}
+	if n := served(); n[1] != 0 || n[0]+n[2] != 6 {
+		t.Errorf("unhealthy backend didn't pass. served:%v", n)
+	}
+}
+
+// TestFileResolver 测试修改后端列表文件后客户端连接新增的后端并关闭移除的后端.
+func TestFileResolver(t *testing.T) {
+	addrs, _, _ := startBackends(t, 2)
+	dir, err := ioutil.TempDir("", "rpc")
+	if err != nil {
+		t.Fatalf("TempDir failed. err:%v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "backends")
+	write := func(content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile failed. err:%v", err)
+		}
+	}
+
+	write("# tcp servers\n" + addrs[0] + "\n\n")
+	client, err := ResolverClient(1, FileResolver(file), ResolveInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("ResolverClient failed. err:%v", err)
+	}
+	defer client.Close()
+
+	var tests = []struct {
+		content string
+		addrs   []string
+	}{
+		{addrs[0] + "\n" + addrs[1] + "\n", []string{addrs[0], addrs[1]}},
+		{addrs[1] + "\n", []string{addrs[1]}},
+		{"", []string{addrs[1]}}, // 空列表保持不变.
+	}
+	for _, test := range tests {
+		sort.Strings(test.addrs)
+		write(test.content)
+		//等待客户端至少重新读取几次文件.
+		time.Sleep(50 * time.Millisecond)
+		deadline := time.Now().Add(time.Second)
+		for {
+			var got []string
+			for addr := range client.BackendHealth() {
+				got = append(got, addr)
+			}
+			sort.Strings(got)
+			if reflect.DeepEqual(got, test.addrs) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("file resolver didn't pass. content:%q, backends:%v", test.content, got)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		var resp echoResp
+		if err := client.Call("Echo", echoReq{Msg: "hello"}, &resp); err != nil {
+			t.Errorf("Call didn't pass. content:%q, err:%v", test.content, err)
+		}
+	}
+}
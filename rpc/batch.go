@@ -0,0 +1,169 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// BatchMethod 批量调用经过客户端拦截器时使用的方法名.
+const BatchMethod = "rpc.Batch"
+
+// batchOrdered 批量请求body首字节的标志位: 服务端按顺序逐个处理调用.
+const batchOrdered byte = 1
+
+// Batch 批量调用: 把多个调用放在一个帧中发送, 服务端(并行地)处理后把所有结果放在一个帧中返回,
+// 只需要一次往返. 每个调用有自己的状态, 一个调用失败不影响其他调用.
+//
+//	batch := client.Batch()
+//	p1 := batch.Add("User.GetProfile", protocol.ReqGetProfile{...}, &resp1)
+//	p2 := batch.Add("User.GetProfile", protocol.ReqGetProfile{...}, &resp2)
+//	if err := batch.Do(ctx); err != nil { ... } // 整个批量调用失败, 如连接断开、超时.
+//	if p1.Err != nil { ... }                      // 单个调用失败.
+//
+// 批量调用作为一次方法名为BatchMethod的调用经过客户端拦截器, req为*Batch, resp为nil;
+// 所有调用的方法都是幂等的(见Idempotent)时批量调用也是幂等的.
+type Batch struct {
+	client  *RPCClient
+	ordered bool
+	calls   []*BatchCall
+}
+
+// BatchCall 批量调用中的一个调用.
+type BatchCall struct {
+	Method string
+	Req    interface{}
+	Resp   interface{}
+	Err    error // Do返回后为该调用的结果, 服务端返回的失败状态以*Error表示.
+}
+
+// Batch 创建一个空的批量调用.
+func (r *RPCClient) Batch() *Batch {
+	return &Batch{client: r}
+}
+
+// Add 添加调用method(req), Do成功返回后应答保存在resp中.
+func (b *Batch) Add(method string, req interface{}, resp interface{}) *BatchCall {
+	call := &BatchCall{Method: method, Req: req, Resp: resp}
+	b.calls = append(b.calls, call)
+	return call
+}
+
+// Ordered 要求服务端按添加的顺序逐个处理调用, 用于后面的调用依赖前面调用的结果的情况(如先修改再读取).
+// 前面的调用失败时后面的调用仍然会被处理.
+func (b *Batch) Ordered() *Batch {
+	b.ordered = true
+	return b
+}
+
+// Calls 返回批量调用中的所有调用.
+func (b *Batch) Calls() []*BatchCall {
+	return b.calls
+}
+
+// Do 发送所有调用并等待结果. 返回错误时整个批量调用失败, 每个调用的Err也被设置为该错误;
+// 返回nil时每个调用的结果见BatchCall.Err.
+func (b *Batch) Do(ctx context.Context) error {
+	if len(b.calls) == 0 {
+		return nil
+	}
+	info := &CallInfo{Method: BatchMethod, Idempotent: true}
+	for _, call := range b.calls {
+		info.Idempotent = info.Idempotent && b.client.idempotent[call.Method]
+	}
+	err := b.client.intercept(info, func(ctx context.Context, req interface{}, resp interface{}) error {
+		return b.client.invokeBatch(ctx, req.(*Batch))
+	})(ctx, b, nil)
+	if err != nil {
+		for _, call := range b.calls {
+			call.Err = err
+		}
+	}
+	return err
+}
+
+// invokeBatch 把b中的调用编码为一个批量请求帧发送, 并把应答帧中每个调用的结果保存到对应的BatchCall中.
+func (r *RPCClient) invokeBatch(ctx context.Context, b *Batch) error {
+	calls := make([]frame, len(b.calls))
+	for i, call := range b.calls {
+		f, err := r.packRequest(call.Method, call.Req)
+		if err != nil {
+			return err
+		}
+		f.id = uint64(i)
+		calls[i] = f
+	}
+	body, err := packBatch(b.ordered, calls, r.opts.maxMessageSize)
+	if err != nil {
+		return err
+	}
+	f := frame{typ: frameBatch, codec: r.opts.codec, id: atomic.AddUint64(&r.seq, 1), body: body}
+	rsp, err := r.roundTrip(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	_, results, err := unpackBatch(rsp.body, r.opts.maxMessageSize)
+	if err != nil {
+		return err
+	}
+	if len(results) != len(b.calls) {
+		return errors.New("rpc: batch response doesn't match the request")
+	}
+	for _, result := range results {
+		if result.id >= uint64(len(b.calls)) {
+			return errors.New("rpc: batch response doesn't match the request")
+		}
+		call := b.calls[result.id]
+		if result.status != OK {
+			call.Err = &Error{Code: result.status, Message: string(result.body)}
+			continue
+		}
+		call.Err = r.unpackResponse(call.Resp, result)
+	}
+	return nil
+}
+
+// packBatch 把frames依次编码为二进制帧, 作为批量请求帧或其应答帧的body.
+// body的首字节是标志位(见batchOrdered), 之后是各个帧.
+func packBatch(ordered bool, frames []frame, maxSize int) ([]byte, error) {
+	var buff bytes.Buffer
+	if ordered {
+		buff.WriteByte(batchOrdered)
+	} else {
+		buff.WriteByte(0)
+	}
+	for _, f := range frames {
+		b, err := packFrame(f, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		if buff.Len()+len(b) > maxSize {
+			return nil, ErrMessageTooLarge
+		}
+		buff.Write(b)
+	}
+	return buff.Bytes(), nil
+}
+
+// unpackBatch 解析packBatch编码的body.
+func unpackBatch(body []byte, maxSize int) (ordered bool, frames []frame, err error) {
+	if len(body) == 0 {
+		return false, nil, errors.New("rpc: empty batch")
+	}
+	ordered = body[0]&batchOrdered != 0
+	r := bytes.NewReader(body[1:])
+	for r.Len() > 0 {
+		f, err := readFrame(r, maxSize)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil, errors.New("rpc: truncated batch")
+		}
+		if err != nil {
+			return false, nil, err
+		}
+		frames = append(frames, f)
+	}
+	return ordered, frames, nil
+}
@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestBatch 测试批量调用: 每个调用有自己的状态, 服务端并行处理, Ordered时按顺序处理.
+func TestBatch(t *testing.T) {
+	client := startServer(t, UseCodec(CodecMsgpack))
+
+	var tests = []struct {
+		name string
+		req  echoReq
+		code Code
+		msg  string
+	}{
+		{"Echo", echoReq{Msg: "bot1", Sleep: 100}, OK, "bot1"},
+		{"Greeter.Hello", echoReq{Msg: "bot2"}, OK, "hello bot2"},
+		{"Greeter.Fail", echoReq{Msg: "bot3"}, NotFound, ""},
+		{"Greeter.Panic", echoReq{}, Internal, ""},
+		{"NoExist", echoReq{}, NotFound, ""},
+		{"Echo", echoReq{Msg: "bot4", Sleep: 100}, OK, "bot4"},
+	}
+	batch := client.Batch()
+	resps := make([]echoResp, len(tests))
+	for i, test := range tests {
+		batch.Add(test.name, test.req, &resps[i])
+	}
+	start := time.Now()
+	if err := batch.Do(context.Background()); err != nil {
+		t.Fatalf("Batch didn't pass. err:%v", err)
+	}
+	if cost := time.Since(start); cost >= 200*time.Millisecond {
+		t.Errorf("Batch didn't run in parallel. cost:%v", cost)
+	}
+	for i, call := range batch.Calls() {
+		if CodeOf(call.Err) != tests[i].code || resps[i].Msg != tests[i].msg {
+			t.Errorf("Batch didn't pass. name:%s, resp:%v, err:%v", tests[i].name, resps[i], call.Err)
+		}
+	}
+
+	//Ordered时逐个处理, 耗时是各个调用之和.
+	batch = client.Batch().Ordered()
+	for i := 0; i < 2; i++ {
+		batch.Add("Echo", echoReq{Msg: "bot1", Sleep: 100}, &echoResp{})
+	}
+	start = time.Now()
+	if err := batch.Do(context.Background()); err != nil {
+		t.Fatalf("Batch didn't pass. err:%v", err)
+	}
+	if cost := time.Since(start); cost < 200*time.Millisecond {
+		t.Errorf("Ordered batch didn't run in order. cost:%v", cost)
+	}
+
+	//整个批量调用超时时每个调用都返回DeadlineExceeded.
+	batch = client.Batch()
+	batch.Add("Echo", echoReq{Msg: "bot1", Sleep: 1000}, &echoResp{})
+	batch.Add("Greeter.Hello", echoReq{}, &echoResp{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := batch.Do(ctx); CodeOf(err) != DeadlineExceeded {
+		t.Errorf("Batch timeout didn't pass. err:%v", err)
+	}
+	for _, call := range batch.Calls() {
+		if CodeOf(call.Err) != DeadlineExceeded {
+			t.Errorf("Batch timeout didn't pass. name:%s, err:%v", call.Method, call.Err)
+		}
+	}
+}
+
+// TestBatchInterceptor 测试批量调用作为一次BatchMethod调用经过客户端拦截器.
+func TestBatchInterceptor(t *testing.T) {
+	client := startServer(t)
+	client.Idempotent("Echo")
+
+	var mu sync.Mutex
+	var infos []CallInfo
+	client.Use(func(ctx context.Context, info *CallInfo, req interface{}, resp interface{}, invoker Invoker) error {
+		mu.Lock()
+		infos = append(infos, *info)
+		mu.Unlock()
+		return invoker(ctx, req, resp)
+	})
+
+	var tests = []struct {
+		methods    []string
+		idempotent bool
+	}{
+		{[]string{"Echo", "Echo"}, true},
+		{[]string{"Echo", "Greeter.Hello"}, false},
+	}
+	for _, test := range tests {
+		infos = nil
+		batch := client.Batch()
+		for _, method := range test.methods {
+			batch.Add(method, echoReq{Msg: "hello"}, &echoResp{})
+		}
+		if err := batch.Do(context.Background()); err != nil {
+			t.Fatalf("Batch failed. err:%v", err)
+		}
+		if len(infos) != 1 || infos[0].Method != BatchMethod || infos[0].Idempotent != test.idempotent {
+			t.Errorf("Batch interceptor didn't pass. methods:%v, infos:%v", test.methods, infos)
+		}
+	}
+}
+
+// TestPackBatch 测试批量请求body的编解码以及不完整的body.
+func TestPackBatch(t *testing.T) {
+	frames := []frame{
+		{typ: frameRequest, codec: CodecJSON, id: 0, name: "Echo", body: []byte(`{"msg":"hello"}`)},
+		{typ: frameRequest, codec: CodecJSON, id: 1, name: "Greeter.Hello", body: []byte(`{}`)},
+	}
+	body, err := packBatch(true, frames, DefaultMaxMessageSize)
+	if err != nil {
+		t.Fatalf("packBatch failed. err:%v", err)
+	}
+	ordered, got, err := unpackBatch(body, DefaultMaxMessageSize)
+	if err != nil || !ordered || len(got) != len(frames) || got[1].name != "Greeter.Hello" || string(got[0].body) != `{"msg":"hello"}` {
+		t.Errorf("unpackBatch didn't pass. ordered:%v, frames:%v, err:%v", ordered, got, err)
+	}
+
+	for _, bad := range [][]byte{nil, body[:len(body)-1], append([]byte{0}, 1, 2, 3)} {
+		if _, _, err := unpackBatch(bad, DefaultMaxMessageSize); err == nil {
+			t.Errorf("unpackBatch didn't fail. body:%v", bad)
+		}
+	}
+	if _, err := packBatch(false, frames, 40); err != ErrMessageTooLarge {
+		t.Errorf("packBatch didn't check size. err:%v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"usermana/log"
+)
+
+// ErrCircuitOpen 方法的熔断器处于打开状态, 调用没有发送到服务端.
+var ErrCircuitOpen = errors.New("rpc: circuit breaker is open")
+
+// breakerState 熔断器的状态.
+type breakerState int
+
+// 熔断器状态.
+const (
+	breakerClosed   breakerState = iota // 正常放行.
+	breakerOpen                         // 直接拒绝, 经过cooldown后进入半开.
+	breakerHalfOpen                     // 放行一个探测请求, 根据其结果关闭或重新打开.
+)
+
+var breakerStateNames = map[breakerState]string{
+	breakerClosed:   "closed",
+	breakerOpen:     "open",
+	breakerHalfOpen: "half-open",
+}
+
+// breaker 一个方法的熔断状态.
+type breaker struct {
+	state    breakerState
+	failures int       // 连续失败的次数.
+	openedAt time.Time // 最近一次打开的时间.
+	probing  bool      // 半开状态下探测请求是否正在进行.
+}
+
+// CircuitBreaker 按方法熔断: 连续失败threshold次后打开, 打开期间调用直接返回Unavailable(ErrCircuitOpen),
+// 经过cooldown后进入半开状态并放行一个探测请求, 成功则关闭, 失败则重新打开.
+// 只有Unavailable、DeadlineExceeded和Internal视为失败, NotFound、BadRequest等调用方的错误不影响熔断.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewCircuitBreaker 创建熔断器, 通过Interceptor接入客户端.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, breakers: make(map[string]*breaker)}
+}
+
+// Interceptor 熔断的客户端拦截器.
+func (c *CircuitBreaker) Interceptor(ctx context.Context, info *CallInfo, req interface{}, resp interface{}, invoker Invoker) error {
+	if !c.allow(info.Method) {
+		return unavailable(ErrCircuitOpen)
+	}
+	err := invoker(ctx, req, resp)
+	c.record(info.Method, err)
+	return err
+}
+
+// State 返回method的熔断器状态: closed, open或half-open.
+func (c *CircuitBreaker) State(method string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.breakers[method]; ok {
+		return breakerStateNames[b.state]
+	}
+	return breakerStateNames[breakerClosed]
+}
+
+// allow 返回是否放行method的调用.
+func (c *CircuitBreaker) allow(method string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[method]
+	if !ok {
+		b = &breaker{}
+		c.breakers[method] = b
+	}
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < c.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record 根据调用结果更新method的熔断状态.
+func (c *CircuitBreaker) record(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.breakers[method]
+	if b.state == breakerHalfOpen {
+		b.probing = false
+	}
+	switch {
+	case serverFailure(err):
+		b.failures++
+		if b.state == breakerHalfOpen || b.state == breakerClosed && b.failures >= c.threshold {
+			log.Warningf("rpc.Call: circuit breaker of %s opened. failures:%d, err:%q", method, b.failures, err)
+			b.state, b.openedAt = breakerOpen, time.Now()
+		}
+	case err == nil || !rejected(err) && !errors.Is(err, context.Canceled):
+		//调用成功或者是调用方的错误, 说明服务端正常.
+		if b.state != breakerClosed {
+			log.Infof("rpc.Call: circuit breaker of %s closed.", method)
+		}
+		b.state, b.failures = breakerClosed, 0
+	}
+}
+
+// serverFailure 返回err是否说明服务端出了问题(不可用、超时或内部错误).
+func serverFailure(err error) bool {
+	if err == nil || rejected(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	switch CodeOf(err) {
+	case Unavailable, DeadlineExceeded, Internal:
+		return true
+	}
+	return false
+}
+
+// rejected 返回调用是否被熔断器或并发限制在本地拒绝.
+func rejected(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrLimitExceeded)
+}
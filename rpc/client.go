@@ -1,148 +1,367 @@
 package rpc
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"io"
+	"fmt"
 	"net"
-	"strconv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"usermana/log"
 )
 
-//RPCClient rpc客户端 包含一个连接池(连接rpc 服务器).
+// ErrNoBackends 没有任何可以连接的rpc服务端.
+var ErrNoBackends = errors.New("rpc: no backends")
+
+//RPCClient rpc客户端 可以连接多个rpc服务器(后端), 按负载均衡策略(见LoadBalance)选择后端,
+//到每个后端有若干条连接，每条连接上可以同时进行多个请求.
+//连接断开后会自动重连, 重连期间以及服务端关闭(draining)期间轮询会跳过该连接, 没有可用连接的后端不参与负载均衡.
 type RPCClient struct {
-	pool chan net.TCPConn
+	seq          uint64 // 请求id生成器, 原子读写, 放在首位保证64位对齐.
+	next         uint32 // 轮询选择后端的计数.
+	connections  int    // 到每个后端的连接数.
+	resolver     Resolver
+	interceptors []ClientInterceptor
+	idempotent   map[string]bool // 幂等的方法, 可以安全地重试.
+	opts         options
+
+	mu       sync.RWMutex
+	backends []*backend // 按地址排序.
+	ring     *hashRing  // backends的一致性哈希环.
+	closed   bool
+	done     chan struct{} // 关闭时通知watch退出.
+}
+
+// CallInfo 客户端拦截器可以获取的调用信息.
+type CallInfo struct {
+	Method     string // 方法名, eg:User.Login.
+	Idempotent bool   // 方法是否幂等(见Idempotent).
 }
 
-//Client 创建connections个tcp连接, 连接到address中，并且将连接保存到连接池作为返回值返回.
-func Client(connections int, address string) (RPCClient, error) {
-	tcpAddr, err := net.ResolveTCPAddr("tcp4", address)
+// Invoker 发送请求req并把应答保存到resp中.
+type Invoker func(ctx context.Context, req interface{}, resp interface{}) error
+
+// ClientInterceptor 客户端拦截器, 可以在调用invoker前后加入重试、日志、统计等通用逻辑.
+type ClientInterceptor func(ctx context.Context, info *CallInfo, req interface{}, resp interface{}, invoker Invoker) error
+
+// PoolHealth 客户端连接池的健康状况.
+type PoolHealth struct {
+	Live      int // 正常的连接数.
+	Dead      int // 已断开且不再重连的连接数(客户端已关闭).
+	Redialing int // 正在重连的连接数.
+	Draining  int // 服务端正在关闭, 等待未完成请求的连接数.
+}
+
+//Client 创建connections个连接, 连接到address(见ParseAddr)中，并且将连接保存到连接池作为返回值返回.
+//客户端总是使用二进制帧，旧版ASCII帧只由服务端兼容(升级时需先升级服务端, 连接旧版服务端时调用返回包装了ErrBadMagic的Unavailable).
+func Client(connections int, address string, opts ...Option) (*RPCClient, error) {
+	return ResolverClient(connections, StaticResolver{address}, opts...)
+}
+
+//ResolverClient 通过resolver发现后端, 到每个后端创建connections个tcp连接.
+//至少连接上一个后端时返回成功, 之后每隔ResolveInterval重新发现后端(StaticResolver除外).
+func ResolverClient(connections int, resolver Resolver, opts ...Option) (*RPCClient, error) {
+	r := newClient(connections, resolver, opts)
+	addrs, err := resolver.Resolve(context.Background())
 	if err != nil {
-		return RPCClient{}, err
+		return nil, fmt.Errorf("rpc: init client failed: %w", err)
 	}
-
-	//创建connections个连接，并将其保存到pool连接池中.
-	pool := make(chan net.TCPConn, connections)
-	for i := 0; i < connections; i++ {
-		//laddr 本地地址默认.
-		conn, err := net.DialTCP("tcp4", nil, tcpAddr)
-		if err != nil {
-			return RPCClient{}, errors.New("rpc: init client failed")
-		}
-		pool <- *conn
+	if err := r.update(addrs); err != nil {
+		return nil, fmt.Errorf("rpc: init client failed: %w", err)
+	}
+	if _, static := resolver.(StaticResolver); !static {
+		go r.watch()
 	}
-	return RPCClient{pool: pool}, nil
+	return r, nil
 }
 
 //Call 对外提供方法， 调用服务端方法， resp必须为指针类型，保存返回结果数据.
 func (r *RPCClient) Call(name string, req interface{}, resp interface{}) error {
-	return r.call(name, req, resp)
+	return r.call(context.Background(), name, req, resp)
 }
 
-//call 真正rpc调用逻辑，  使用rpc调用函数name(req), 并将结果保存到resp中.
-func (r *RPCClient) call(name string, req interface{}, resp interface{}) error {
-	//从连接池获取一个空闲连接.
-	conn := r.getConn()
-	defer r.releaseConn(conn)
+// CallContext 与Call相同, 但在ctx被取消或超时后立即返回.
+// ctx的剩余时间会随请求发送到服务端, 服务端处理函数收到的context在同一时刻超时.
+func (r *RPCClient) CallContext(ctx context.Context, name string, req interface{}, resp interface{}) error {
+	return r.call(ctx, name, req, resp)
+}
 
-	//对请求进行封装.
-	reqBytes, err := r.packRequest(name, req)
+// InMemoryClient 创建直接连接到同一进程中server的客户端, 用于不依赖网络的测试.
+//连接使用net.Pipe, 请求和应答与网络连接一样经过二进制帧和codec, 因此可以发现编解码的问题.
+func InMemoryClient(server *RPCServer, opts ...Option) (*RPCClient, error) {
+	r := newClient(1, StaticResolver{"memory"}, opts)
+	dial := func() (net.Conn, error) {
+		client, conn := net.Pipe()
+		go server.handle(conn)
+		return client, nil
+	}
+	b, err := newBackend("memory", dial, 1, &r.opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	//将数据发送到rpc服务器
-	conn.Write(reqBytes)
+	r.backends, r.ring = []*backend{b}, newHashRing([]*backend{b})
+	return r, nil
+}
 
-	//首先读取数据包的大小，保存到n.
-	dataLen := make([]byte, PackMaxSize)
-	n, err := conn.Read(dataLen)
-	if err != nil && err != io.EOF {
-		return err
+// newClient 返回还没有任何后端的客户端.
+func newClient(connections int, resolver Resolver, opts []Option) *RPCClient {
+	return &RPCClient{
+		connections: connections,
+		resolver:    resolver,
+		idempotent:  make(map[string]bool),
+		opts:        defaultOptions(opts),
+		done:        make(chan struct{}),
+	}
+}
+
+// Use 注册客户端拦截器, 按注册顺序由外到内包裹每次调用. 需要在调用Call之前调用.
+func (r *RPCClient) Use(interceptors ...ClientInterceptor) {
+	r.interceptors = append(r.interceptors, interceptors...)
+}
+
+// Idempotent 声明methods是幂等的(多次调用与一次调用效果相同), RetryInterceptor只会重试幂等的方法.
+// 需要在调用Call之前调用.
+func (r *RPCClient) Idempotent(methods ...string) {
+	for _, method := range methods {
+		r.idempotent[method] = true
 	}
-	if n <= 0 {
-		return errors.New("rpc.Call: not data")
+}
+
+// Close 关闭所有连接, 未完成的请求返回Unavailable.
+func (r *RPCClient) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
 	}
+	r.closed = true
+	close(r.done)
+	backends := r.backends
+	r.mu.Unlock()
 
-	//将一个dataLen转为64位int，保存到len中.
-	len, err := strconv.ParseInt(string(dataLen[:PackMaxSize]), 10, 64)
-	if err != nil {
-		return err
+	for _, b := range backends {
+		b.close()
 	}
-	//创建长度为len的字符数组buff，准备接收应答数据.
-	buff := make([]byte, len)
-	//读取长度为len的数据到buff中
-	n, err = conn.Read(buff)
-	if err != nil && err != io.EOF {
-		return err
+	return nil
+}
+
+// Health 返回所有后端的连接池中各状态连接的数量.
+func (r *RPCClient) Health() PoolHealth {
+	var h PoolHealth
+	for _, bh := range r.BackendHealth() {
+		h.Live += bh.Live
+		h.Dead += bh.Dead
+		h.Redialing += bh.Redialing
+		h.Draining += bh.Draining
 	}
-	if n <= 0 {
-		return errors.New("rpc.Call: not data")
+	return h
+}
+
+// BackendHealth 返回每个后端地址对应的连接池健康状况.
+func (r *RPCClient) BackendHealth() map[string]PoolHealth {
+	r.mu.RLock()
+	backends := r.backends
+	r.mu.RUnlock()
+
+	health := make(map[string]PoolHealth, len(backends))
+	for _, b := range backends {
+		health[b.addr] = b.health()
 	}
+	return health
+}
 
-	//解析json数据buff，保存到resp数据结构中.
-	if err = r.unpackResponse(resp, buff); err != nil {
-		return err
+// watch 每隔resolveInterval重新发现后端, 直到客户端关闭.
+func (r *RPCClient) watch() {
+	ticker := time.NewTicker(r.opts.resolveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), r.opts.resolveInterval)
+		addrs, err := r.resolver.Resolve(ctx)
+		cancel()
+		if err != nil {
+			log.Warningf("rpc.Client: resolve backends failed, keep current backends. err:%q", err)
+			continue
+		}
+		if err := r.update(addrs); err != nil {
+			log.Warningf("rpc.Client: update backends failed, keep current backends. err:%q", err)
+		}
+	}
+}
+
+// update 将后端更新为addrs: 连接新增的后端, 关闭已经移除的后端.
+// 新增的后端连接失败时跳过, 下次发现时再重试. addrs为空或者一个后端都连接不上时保持不变并返回错误.
+func (r *RPCClient) update(addrs []string) error {
+	r.mu.RLock()
+	current := make(map[string]*backend, len(r.backends))
+	for _, b := range r.backends {
+		current[b.addr] = b
+	}
+	r.mu.RUnlock()
+
+	var backends []*backend
+	var dialErr error
+	seen := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		if b, ok := current[addr]; ok {
+			backends = append(backends, b)
+			continue
+		}
+		b, err := dialBackend(addr, r.connections, &r.opts)
+		if err != nil {
+			log.Warningf("rpc.Client: dial backend %s failed. err:%q", addr, err)
+			dialErr = err
+			continue
+		}
+		log.Infof("rpc.Client: backend %s added.", addr)
+		backends = append(backends, b)
+	}
+	if len(backends) == 0 {
+		if dialErr != nil {
+			return dialErr
+		}
+		return ErrNoBackends
+	}
+	sort.Slice(backends, func(i, j int) bool { return backends[i].addr < backends[j].addr })
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		for _, b := range backends {
+			if current[b.addr] == nil {
+				b.close()
+			}
+		}
+		return ErrConnClosed
+	}
+	r.backends, r.ring = backends, newHashRing(backends)
+	r.mu.Unlock()
+
+	for addr, b := range current {
+		if !seen[addr] {
+			log.Infof("rpc.Client: backend %s removed.", addr)
+			b.close()
+		}
 	}
 	return nil
+}
 
+//call 依次经过拦截器, 最后由invoke完成调用.
+func (r *RPCClient) call(ctx context.Context, name string, req interface{}, resp interface{}) error {
+	info := &CallInfo{Method: name, Idempotent: r.idempotent[name]}
+	return r.intercept(info, func(ctx context.Context, req interface{}, resp interface{}) error {
+		return r.invoke(ctx, name, req, resp)
+	})(ctx, req, resp)
+}
+
+// intercept 用注册的拦截器依次包裹invoker.
+func (r *RPCClient) intercept(info *CallInfo, invoker Invoker) Invoker {
+	for i := len(r.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := r.interceptors[i], invoker
+		invoker = func(ctx context.Context, req interface{}, resp interface{}) error {
+			return interceptor(ctx, info, req, resp, next)
+		}
+	}
+	return invoker
 }
 
-// getConn 从RPCClient连接池中随机取出一个空闲连接。 如果没有，那将会阻塞.
-func (r *RPCClient) getConn() (conn net.TCPConn) {
-	select {
-	case conn := <-r.pool:
-		return conn
+//invoke 真正rpc调用逻辑，  使用rpc调用函数name(req), 并将结果保存到resp中.
+//服务端返回的失败状态、连接错误和超时都以*Error返回.
+func (r *RPCClient) invoke(ctx context.Context, name string, req interface{}, resp interface{}) error {
+	//对请求进行封装.
+	f, err := r.packRequest(name, req)
+	if err != nil {
+		return err
 	}
+	rsp, err := r.roundTrip(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	//解析应答数据，保存到resp数据结构中.
+	return r.unpackResponse(resp, rsp)
 }
 
-//releaseConn 将连接conn重新写入到连接池中.
-func (r *RPCClient) releaseConn(conn net.TCPConn) {
-	select {
-	case r.pool <- conn:
-		// TODO 返回空值怎么可行？
-		return
+// roundTrip 按负载均衡策略选择后端发送请求帧f, 并等待对应id的应答帧.
+// 服务端返回的失败状态、连接错误和超时都以*Error返回.
+func (r *RPCClient) roundTrip(ctx context.Context, f frame) (frame, error) {
+	//计算请求剩余的处理时间.
+	if deadline, ok := ctx.Deadline(); ok {
+		f.timeout = time.Until(deadline)
+		if f.timeout <= 0 {
+			return frame{}, contextError(context.DeadlineExceeded)
+		}
+	}
+
+	//按负载均衡策略选择后端, 再选择一个连接发送请求，并等待对应id的应答.
+	b := r.getBackend(ctx)
+	atomic.AddInt64(&b.outstanding, 1)
+	rsp, err := b.getConn().roundTrip(ctx, f)
+	atomic.AddInt64(&b.outstanding, -1)
+	if err != nil {
+		return frame{}, err
+	}
+	if rsp.status != OK {
+		return frame{}, &Error{Code: rsp.status, Message: string(rsp.body)}
 	}
+	return rsp, nil
+}
+
+// getBackend 按负载均衡策略选择一个后端. 客户端创建成功后至少有一个后端.
+func (r *RPCClient) getBackend(ctx context.Context) *backend {
+	r.mu.RLock()
+	backends, ring := r.backends, r.ring
+	r.mu.RUnlock()
+	return pick(ctx, r.opts.balance, backends, ring, atomic.AddUint32(&r.next, 1))
 }
 
-//packRequest 对请求数据进行json封装，然后返回其对应的字符数组.
+//packRequest 使用客户端的codec对请求数据进行编码, 生成请求帧.
 /*
-	以字符串拼接[name,v]
 	封装之前
 	eg:
-		name: Login
+		name: User.Login
 		(v)protocol.ReqLogin{
 			UserName: userName,
 			Password: password,
 		}
 
-	封装之后，以字符串的形式返回如下内容。
-	body的长度
-	{
-		Name:  函数名的字符串
-		Data：
-			{
-				UserName: userName,
-				Password: password,
-			}
-	}
+	封装之后, 方法名和codec放在帧中(见pack.go), body只是v编码后的结果, eg(json):
+	{"user_name":"bot1","password":"1234"}
 */
-func (r *RPCClient) packRequest(name string, v interface{}) ([]byte, error) {
-	dataBytes, err := json.Marshal(v)
+func (r *RPCClient) packRequest(name string, v interface{}) (frame, error) {
+	codec, err := getCodec(r.opts.codec)
 	if err != nil {
-		return nil, err
+		return frame{}, err
 	}
-
-	reqSt := request{Name: name, Data: dataBytes}
-	reqBytes, err := pack(reqSt)
+	body, err := codec.Marshal(v)
 	if err != nil {
-		return nil, err
+		return frame{}, err
 	}
-	return reqBytes, nil
+	return frame{
+		typ:   frameRequest,
+		codec: r.opts.codec,
+		id:    atomic.AddUint64(&r.seq, 1),
+		name:  name,
+		body:  body,
+	}, nil
 }
 
-//unpackResponse 将respBytes数据拆包，保存到resp中.
-func (r *RPCClient) unpackResponse(resp interface{}, respBytes []byte) (err error) {
-	if err := json.Unmarshal(respBytes, resp); err != nil {
+//unpackResponse 使用应答帧中的codec解码应答数据，保存到resp中.
+func (r *RPCClient) unpackResponse(resp interface{}, rsp frame) error {
+	codec, err := getCodec(rsp.codec)
+	if err != nil {
 		return err
 	}
-	return nil
+	return codec.Unmarshal(rsp.body, resp)
 }
@@ -0,0 +1,106 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec 请求和应答body的编解码方式.
+type Codec interface {
+	// Marshal 将v编码为字符数组.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal 将data解码到v中, v必须为指针类型.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// 内置的编解码方式, 作为帧中的content-type.
+const (
+	CodecJSON     byte = iota + 1 // encoding/json.
+	CodecMsgpack                  // MessagePack, 字段名与json tag一致.
+	CodecProtobuf                 // Protobuf, 参数和返回值必须是proto.Message.
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[byte]Codec{
+		CodecJSON:     jsonCodec{},
+		CodecMsgpack:  msgpackCodec{},
+		CodecProtobuf: protoCodec{},
+	}
+)
+
+// RegisterCodec 注册编号为id的编解码方式, 客户端和服务端需要注册相同的codec.
+func RegisterCodec(id byte, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[id] = c
+}
+
+// getCodec 返回编号为id的编解码方式.
+func getCodec(id byte) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("rpc: unknown codec %d", id)
+	}
+	return c, nil
+}
+
+// jsonCodec 使用encoding/json编解码.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// msgpackCodec 使用MessagePack编解码. 使用json tag作为字段名, protocol中的结构体不需要额外的tag.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// errNotProtoMessage 使用Protobuf编解码非proto.Message类型.
+var errNotProtoMessage = errors.New("rpc: protobuf codec requires proto.Message")
+
+// protoCodec 使用Protobuf编解码, 只支持由protoc生成的消息类型.
+type protoCodec struct{}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok && v != nil {
+		return nil, errNotProtoMessage
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errNotProtoMessage
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,150 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// codecReq 与protocol中的结构体类似, 只有json tag.
+type codecReq struct {
+	UserName string `json:"user_name"`
+	NickName string `json:"nick_name"`
+	Ret      int    `json:"ret"`
+}
+
+// TestCodec 测试json和MessagePack的编解码.
+func TestCodec(t *testing.T) {
+	in := codecReq{"bot1", "昵称", 2}
+	for _, id := range []byte{CodecJSON, CodecMsgpack} {
+		codec, err := getCodec(id)
+		if err != nil {
+			t.Fatalf("getCodec failed. codec:%d, err:%v", id, err)
+		}
+		var out codecReq
+		data, err := codec.Marshal(in)
+		if err == nil {
+			err = codec.Unmarshal(data, &out)
+		}
+		if err != nil || !reflect.DeepEqual(in, out) {
+			t.Errorf("codec didn't pass. codec:%d, out:%v, err:%v", id, out, err)
+		}
+	}
+}
+
+// TestProtoCodec 测试Protobuf编解码, 非proto.Message类型应当失败.
+func TestProtoCodec(t *testing.T) {
+	codec, err := getCodec(CodecProtobuf)
+	if err != nil {
+		t.Fatalf("getCodec failed. err:%v", err)
+	}
+	data, err := codec.Marshal(wrapperspb.String("bot1"))
+	if err != nil {
+		t.Fatalf("Marshal failed. err:%v", err)
+	}
+	var out wrapperspb.StringValue
+	if err := codec.Unmarshal(data, &out); err != nil || out.GetValue() != "bot1" {
+		t.Errorf("Unmarshal didn't pass. out:%v, err:%v", out.GetValue(), err)
+	}
+
+	if _, err := codec.Marshal(codecReq{}); err != errNotProtoMessage {
+		t.Errorf("Marshal non proto.Message didn't pass. err:%v", err)
+	}
+	if err := codec.Unmarshal(data, &codecReq{}); err != errNotProtoMessage {
+		t.Errorf("Unmarshal non proto.Message didn't pass. err:%v", err)
+	}
+}
+
+// TestCallCodec 测试客户端使用不同codec调用服务端.
+func TestCallCodec(t *testing.T) {
+	for _, codec := range []byte{CodecJSON, CodecMsgpack} {
+		client := startServer(t, UseCodec(codec))
+		var resp echoResp
+		if err := client.Call("Greeter.Hello", echoReq{Msg: "bot1"}, &resp); err != nil || resp.Msg != "hello bot1" {
+			t.Errorf("Call didn't pass. codec:%d, resp:%v, err:%v", codec, resp, err)
+		}
+	}
+}
+
+// ProtoGreeter 参数和返回值都是protoc生成的消息指针的服务, 测试CodecProtobuf.
+type ProtoGreeter struct{}
+
+// Hello 返回"hello "+req.
+func (*ProtoGreeter) Hello(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	return wrapperspb.String("hello " + req.GetValue()), nil
+}
+
+// Count 依次发送n个消息"0", "1", ....
+func (*ProtoGreeter) Count(ctx context.Context, req *wrapperspb.Int32Value, send func(*wrapperspb.StringValue) error) error {
+	for i := int32(0); i < req.GetValue(); i++ {
+		if err := send(wrapperspb.String(fmt.Sprint(i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestCallProtobuf 测试客户端使用CodecProtobuf调用参数为消息指针的普通方法和流式方法.
+func TestCallProtobuf(t *testing.T) {
+	server := newTestServer(t)
+	if err := server.RegisterService(&ProtoGreeter{}); err != nil {
+		t.Fatalf("RegisterService failed. err:%v", err)
+	}
+	client := serve(t, server, UseCodec(CodecProtobuf))
+
+	var resp wrapperspb.StringValue
+	if err := client.Call("ProtoGreeter.Hello", wrapperspb.String("bot1"), &resp); err != nil || resp.GetValue() != "hello bot1" {
+		t.Errorf("Call didn't pass. resp:%v, err:%v", resp.GetValue(), err)
+	}
+	//非proto.Message的参数在客户端编码时失败.
+	if err := client.Call("ProtoGreeter.Hello", echoReq{Msg: "bot1"}, &resp); err == nil {
+		t.Errorf("Call non proto.Message didn't pass. err:%v", err)
+	}
+
+	stream, err := client.Stream(context.Background(), "ProtoGreeter.Count", wrapperspb.Int32(3))
+	if err != nil {
+		t.Fatalf("Stream failed. err:%v", err)
+	}
+	defer stream.Close()
+	count := 0
+	var msg wrapperspb.StringValue
+	for stream.Next(&msg) {
+		if msg.GetValue() != fmt.Sprint(count) {
+			t.Errorf("Stream didn't pass. count:%d, msg:%v", count, msg.GetValue())
+		}
+		count++
+	}
+	if count != 3 || stream.Err() != nil {
+		t.Errorf("Stream didn't pass. count:%d, err:%v", count, stream.Err())
+	}
+}
+
+// benchmarkCodec 基准测试codec对一个典型请求的编解码.
+func benchmarkCodec(b *testing.B, id byte) {
+	codec, _ := getCodec(id)
+	in := codecReq{"bot1234567", "newbot", 0}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, err := codec.Marshal(in)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var out codecReq
+		if err := codec.Unmarshal(data, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+//BenchmarkCodecJSON 基准测试json编解码.
+func BenchmarkCodecJSON(b *testing.B) {
+	benchmarkCodec(b, CodecJSON)
+}
+
+//BenchmarkCodecMsgpack 基准测试MessagePack编解码.
+func BenchmarkCodecMsgpack(b *testing.B) {
+	benchmarkCodec(b, CodecMsgpack)
+}
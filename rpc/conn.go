@@ -0,0 +1,337 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"usermana/log"
+)
+
+var (
+	// ErrConnClosed 客户端已经关闭, 未完成的请求返回包装了此错误的Unavailable.
+	ErrConnClosed = errors.New("rpc: connection closed")
+	// ErrConnBroken 连接已断开, 正在重连.
+	ErrConnBroken = errors.New("rpc: connection broken, redialing")
+	// ErrHeartbeatTimeout 连接在心跳超时时间内没有收到任何数据.
+	ErrHeartbeatTimeout = errors.New("rpc: heartbeat timeout")
+	// ErrConnDraining 服务端正在关闭, 连接上不再发送新的请求.
+	ErrConnDraining = errors.New("rpc: server is shutting down")
+)
+
+// connState 连接的状态.
+type connState int32
+
+// 连接状态.
+const (
+	stateLive      connState = iota // 连接正常, 可以发送请求.
+	stateDead                       // 连接已断开且不再重连(客户端已关闭).
+	stateRedialing                  // 正在重连.
+	stateDraining                   // 服务端正在关闭(收到goaway帧), 等待未完成的请求返回后重连.
+)
+
+// clientConn 一条多路复用、断线自动重连的连接.
+// 由读协程readLoop把应答分发给等待的调用者, 由heartbeatLoop在连接空闲时发送心跳.
+type clientConn struct {
+	lastRead int64 // 最近一次从连接读到数据的时间(UnixNano), 原子读写, 放在首位保证64位对齐.
+
+	dial func() (net.Conn, error)
+	opts *options
+
+	wmu sync.Mutex // 保证一个帧完整地写入连接.
+
+	mu      sync.Mutex
+	conn    net.Conn              // 当前使用的底层连接.
+	state   connState             // 连接状态.
+	pending map[uint64]chan frame // 请求id -> 等待应答的调用者(或流式调用的Stream).
+	err     error                 // 最近一次连接断开的原因.
+	closed  bool                  // 客户端已经关闭, 不再重连.
+	done    chan struct{}         // 关闭时通知heartbeatLoop和redial退出.
+	writing int                   // 通过了状态检查、正在写入的请求数.
+	wrote   *sync.Cond            // writing减少时通知ackGoAway, 使用mu.
+}
+
+// newClientConn 建立连接并启动读协程和心跳协程.
+func newClientConn(dial func() (net.Conn, error), opts *options) (*clientConn, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	c := &clientConn{
+		dial:     dial,
+		opts:     opts,
+		conn:     conn,
+		state:    stateLive,
+		pending:  make(map[uint64]chan frame),
+		lastRead: time.Now().UnixNano(),
+		done:     make(chan struct{}),
+	}
+	c.wrote = sync.NewCond(&c.mu)
+	go c.readLoop(conn)
+	if opts.heartbeatInterval > 0 {
+		go c.heartbeatLoop()
+	}
+	return c, nil
+}
+
+// getState 返回连接当前的状态.
+func (c *clientConn) getState() connState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// roundTrip 发送请求帧f并阻塞等待对应的应答帧, ctx结束时放弃等待.
+func (c *clientConn) roundTrip(ctx context.Context, f frame) (frame, error) {
+	if err := ctx.Err(); err != nil {
+		return frame{}, contextError(err)
+	}
+	reqBytes, err := packFrame(f, c.opts.maxMessageSize)
+	if err != nil {
+		return frame{}, err
+	}
+
+	ch := make(chan frame, 1)
+	c.mu.Lock()
+	if c.state != stateLive {
+		err = c.unavailableLocked()
+		c.mu.Unlock()
+		return frame{}, err
+	}
+	conn := c.conn
+	c.pending[f.id] = ch
+	c.writing++
+	c.mu.Unlock()
+
+	//将数据发送到rpc服务器.
+	err = c.write(conn, reqBytes)
+	c.endWrite()
+	if err != nil {
+		c.fail(conn, err)
+	}
+
+	select {
+	case rsp, ok := <-ch:
+		if !ok {
+			c.mu.Lock()
+			err = c.unavailableLocked()
+			c.mu.Unlock()
+			return frame{}, err
+		}
+		return rsp, nil
+	case <-ctx.Done():
+		//不再等待该请求, 之后到达的应答会被readLoop丢弃.
+		c.mu.Lock()
+		delete(c.pending, f.id)
+		c.mu.Unlock()
+		return frame{}, contextError(ctx.Err())
+	}
+}
+
+// unavailableLocked 返回连接不可用时的错误, 调用者需持有c.mu.
+func (c *clientConn) unavailableLocked() error {
+	if c.closed {
+		return unavailable(ErrConnClosed)
+	}
+	if c.state == stateDraining {
+		return unavailable(ErrConnDraining)
+	}
+	if c.err != nil {
+		return unavailable(c.err)
+	}
+	return unavailable(ErrConnBroken)
+}
+
+// endWrite 一个通过了状态检查的请求写入完成.
+func (c *clientConn) endWrite() {
+	c.mu.Lock()
+	c.writing--
+	c.wrote.Broadcast()
+	c.mu.Unlock()
+}
+
+// ackGoAway 等待收到goaway帧之前通过了状态检查的请求写入完成, 然后回复goaway帧,
+// 告诉服务端之后不会再在conn上发送新的请求, 服务端可以关闭连接.
+func (c *clientConn) ackGoAway(conn net.Conn) {
+	c.mu.Lock()
+	for c.writing > 0 && c.conn == conn {
+		c.wrote.Wait()
+	}
+	current := c.conn == conn
+	c.mu.Unlock()
+	if current {
+		ack, _ := packFrame(frame{typ: frameGoAway}, c.opts.maxMessageSize)
+		c.write(conn, ack)
+	}
+}
+
+// write 将b完整地写入conn.
+func (c *clientConn) write(conn net.Conn, b []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := conn.Write(b)
+	return err
+}
+
+// readLoop 不断从conn读取应答帧，根据id交给对应的调用者，直到连接出错.
+func (c *clientConn) readLoop(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		f, err := readFrame(reader, c.opts.maxMessageSize)
+		if err != nil {
+			c.fail(conn, err)
+			return
+		}
+		atomic.StoreInt64(&c.lastRead, time.Now().UnixNano())
+
+		switch f.typ {
+		case frameResponse:
+			c.mu.Lock()
+			ch, ok := c.pending[f.id]
+			delete(c.pending, f.id)
+			c.mu.Unlock()
+			if ok {
+				ch <- f
+			}
+		case frameStreamMsg, frameStreamEnd:
+			c.deliverStream(f)
+		case framePong:
+			//收到心跳应答, lastRead已经更新.
+		case frameGoAway:
+			//服务端处理完未完成的请求后会关闭连接, 之后由fail开始重连.
+			c.mu.Lock()
+			if c.conn == conn && c.state == stateLive {
+				c.state = stateDraining
+				log.Infof("rpc.Client: server %s is shutting down, draining.", conn.RemoteAddr())
+				//不能在读协程中等待写入, 写入可能正在等待服务端读取, 而服务端在等待本协程读取应答.
+				go c.ackGoAway(conn)
+			}
+			c.mu.Unlock()
+		default:
+			log.Errorf("rpc.Call: unexpected frame type %d", f.typ)
+		}
+	}
+}
+
+// heartbeatLoop 连接空闲超过heartbeatInterval时发送心跳帧,
+// 空闲超过heartbeatInterval+heartbeatTimeout仍未收到任何数据则认为连接已断开.
+func (c *clientConn) heartbeatLoop() {
+	ticker := time.NewTicker(c.opts.heartbeatInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		conn, state := c.conn, c.state
+		c.mu.Unlock()
+		if state != stateLive && state != stateDraining {
+			continue
+		}
+
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastRead)))
+		if idle >= c.opts.heartbeatInterval+c.opts.heartbeatTimeout {
+			c.fail(conn, ErrHeartbeatTimeout)
+			continue
+		}
+		if idle >= c.opts.heartbeatInterval {
+			ping, _ := packFrame(frame{typ: framePing}, c.opts.maxMessageSize)
+			if err := c.write(conn, ping); err != nil {
+				c.fail(conn, err)
+			}
+		}
+	}
+}
+
+// fail 标记conn已断开(或服务端关闭了draining的连接): 关闭conn, 通知所有等待中的调用者, 然后开始重连.
+// conn已经被替换(重复上报同一个错误)时什么都不做.
+func (c *clientConn) fail(conn net.Conn, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != conn || (c.state != stateLive && c.state != stateDraining) {
+		return
+	}
+	conn.Close()
+	c.err = err
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	log.Warningf("rpc.Client: connection to %s broken, redialing. err:%q", conn.RemoteAddr(), err)
+	c.state = stateRedialing
+	go c.redial()
+}
+
+// redial 以指数退避(带随机抖动)的间隔重连, 直到成功或客户端关闭.
+func (c *clientConn) redial() {
+	backoff := c.opts.redialMinBackoff
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(jitter(backoff)):
+		}
+
+		conn, err := c.dial()
+		if err == nil {
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				conn.Close()
+				return
+			}
+			c.conn = conn
+			c.state = stateLive
+			c.err = nil
+			atomic.StoreInt64(&c.lastRead, time.Now().UnixNano())
+			c.mu.Unlock()
+			go c.readLoop(conn)
+			log.Infof("rpc.Client: connection to %s redialed.", conn.RemoteAddr())
+			return
+		}
+
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		if backoff *= 2; backoff > c.opts.redialMaxBackoff {
+			backoff = c.opts.redialMaxBackoff
+		}
+	}
+}
+
+// jitter 返回[backoff/2, backoff]之间的随机时间, 避免所有连接(或请求)同时重试.
+func jitter(backoff time.Duration) time.Duration {
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// close 关闭连接并停止重连, 未完成的请求返回Unavailable.
+func (c *clientConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+	c.conn.Close()
+	c.state = stateDead
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// contextError 将context超时包装为DeadlineExceeded, 取消则原样返回.
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return &Error{Code: DeadlineExceeded, Message: err.Error(), cause: err}
+	}
+	return err
+}
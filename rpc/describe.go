@@ -0,0 +1,66 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DescribeMethod 服务端内置的方法, 返回注册的所有方法及其参数和返回值类型(Description).
+// Description不是proto.Message, 因此需要使用json或msgpack调用.
+const DescribeMethod = "_describe"
+
+// DescribeRequest DescribeMethod的参数.
+type DescribeRequest struct{}
+
+// Description DescribeMethod的返回.
+type Description struct {
+	Methods []MethodDesc `json:"methods"` // 按方法名排序, 不包含内置的方法.
+}
+
+// MethodDesc 一个注册的方法.
+type MethodDesc struct {
+	Name   string `json:"name"`   // 方法名, eg:User.Login.
+	Args   string `json:"args"`   // 参数类型, eg:protocol.ReqLogin.
+	Reply  string `json:"reply"`  // 返回值类型, 流式方法为消息的类型.
+	Stream bool   `json:"stream"` // 是否是流式方法.
+}
+
+// registerDescribe 注册内置的DescribeMethod.
+func (r *RPCServer) registerDescribe() {
+	r.router[DescribeMethod] = rpcHandler{
+		handler: func(ctx context.Context, req interface{}) (interface{}, error) {
+			return r.describe(), nil
+		},
+		argsType:   reflect.TypeOf(DescribeRequest{}),
+		replysType: reflect.TypeOf(Description{}),
+	}
+}
+
+// describe 返回注册的所有方法, 方法名以_开头的内置方法除外.
+func (r *RPCServer) describe() Description {
+	var d Description
+	for name, rh := range r.router {
+		if strings.HasPrefix(name, "_") {
+			continue
+		}
+		d.Methods = append(d.Methods, MethodDesc{
+			Name:   name,
+			Args:   rh.argsType.String(),
+			Reply:  rh.replysType.String(),
+			Stream: rh.stream != nil,
+		})
+	}
+	sort.Slice(d.Methods, func(i, j int) bool { return d.Methods[i].Name < d.Methods[j].Name })
+	return d
+}
+
+// Describe 调用服务端的DescribeMethod, 返回服务端注册的所有方法.
+func (r *RPCClient) Describe(ctx context.Context) ([]MethodDesc, error) {
+	var d Description
+	if err := r.CallContext(ctx, DescribeMethod, DescribeRequest{}, &d); err != nil {
+		return nil, err
+	}
+	return d.Methods, nil
+}
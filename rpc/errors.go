@@ -0,0 +1,95 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Code rpc应答的状态码, 随应答帧一起返回给客户端.
+type Code uint8
+
+// 状态码.
+const (
+	OK                Code = iota // 成功.
+	NotFound                      // 找不到请求的方法.
+	BadRequest                    // 请求无法解析.
+	Internal                      // 服务端内部错误.
+	DeadlineExceeded              // 请求超过了截止时间.
+	Unavailable                   // 服务暂不可用(如连接断开).
+	ResourceExhausted             // 超过了服务端方法的配额(见Quotas).
+)
+
+var codeNames = map[Code]string{
+	OK:                "OK",
+	NotFound:          "NotFound",
+	BadRequest:        "BadRequest",
+	Internal:          "Internal",
+	DeadlineExceeded:  "DeadlineExceeded",
+	Unavailable:       "Unavailable",
+	ResourceExhausted: "ResourceExhausted",
+}
+
+// String 返回状态码的名字.
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Code(%d)", uint8(c))
+}
+
+// Error rpc调用失败时返回的错误, 调用者可以通过errors.As获取状态码.
+//
+//	var e *rpc.Error
+//	if errors.As(err, &e) && e.Code == rpc.NotFound {
+//		...
+//	}
+type Error struct {
+	Code    Code
+	Message string
+	cause   error // 客户端本地产生的错误的原因(如连接断开), 可以通过errors.Is判断.
+}
+
+// Errorf 创建一个状态码为code的错误.
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Error 实现error接口.
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc: %s: %s", e.Code, e.Message)
+}
+
+// Unwrap 返回错误的原因.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// CodeOf 返回err对应的状态码. err为nil时返回OK, 不是*Error的错误视为Internal.
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return DeadlineExceeded
+	}
+	return Internal
+}
+
+// toError 将任意错误转换为*Error, 用于写入应答帧.
+func toError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Code: CodeOf(err), Message: err.Error(), cause: err}
+}
+
+// unavailable 将客户端本地的连接错误包装为Unavailable.
+func unavailable(err error) *Error {
+	return &Error{Code: Unavailable, Message: err.Error(), cause: err}
+}
@@ -0,0 +1,99 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+	"usermana/log"
+)
+
+// RetryInterceptor 返回重试幂等方法的客户端拦截器: 调用返回Unavailable(如连接断开)时,
+// 以指数退避(带随机抖动)的间隔重试, 从minBackoff开始每次翻倍, 最大为maxBackoff, 最多共调用attempts次.
+// 非幂等的方法、其他状态码以及ctx结束时不重试.
+func RetryInterceptor(attempts int, minBackoff, maxBackoff time.Duration) ClientInterceptor {
+	return func(ctx context.Context, info *CallInfo, req interface{}, resp interface{}, invoker Invoker) error {
+		err := invoker(ctx, req, resp)
+		if !info.Idempotent {
+			return err
+		}
+		backoff := minBackoff
+		for i := 1; i < attempts && CodeOf(err) == Unavailable; i++ {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(jitter(backoff)):
+			}
+			log.Warningf("rpc.Call: retry %s (%d/%d). err:%q", info.Method, i, attempts-1, err)
+			err = invoker(ctx, req, resp)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		return err
+	}
+}
+
+// LogInterceptor 记录每次调用的方法名、耗时和错误的客户端拦截器.
+func LogInterceptor(ctx context.Context, info *CallInfo, req interface{}, resp interface{}, invoker Invoker) error {
+	start := time.Now()
+	err := invoker(ctx, req, resp)
+	if err != nil {
+		log.Errorf("rpc.Call: %s failed. cost:%v, err:%q", info.Method, time.Since(start), err)
+		return err
+	}
+	log.Debugf("rpc.Call: %s done. cost:%v", info.Method, time.Since(start))
+	return nil
+}
+
+// CallCount 一个方法的调用统计.
+type CallCount struct {
+	Calls    uint64          // 调用次数.
+	Failures uint64          // 失败次数.
+	Codes    map[Code]uint64 // 各失败状态码的次数.
+}
+
+// CallStats 按方法统计调用次数和失败次数, 零值即可使用.
+//
+//	var stats rpc.CallStats
+//	client.Use(stats.Interceptor)
+type CallStats struct {
+	mu     sync.Mutex
+	counts map[string]*CallCount
+}
+
+// Interceptor 统计调用结果的客户端拦截器.
+func (s *CallStats) Interceptor(ctx context.Context, info *CallInfo, req interface{}, resp interface{}, invoker Invoker) error {
+	err := invoker(ctx, req, resp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]*CallCount)
+	}
+	c, ok := s.counts[info.Method]
+	if !ok {
+		c = &CallCount{Codes: make(map[Code]uint64)}
+		s.counts[info.Method] = c
+	}
+	c.Calls++
+	if err != nil {
+		c.Failures++
+		c.Codes[CodeOf(err)]++
+	}
+	return err
+}
+
+// Snapshot 返回方法名到调用统计的拷贝.
+func (s *CallStats) Snapshot() map[string]CallCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := make(map[string]CallCount, len(s.counts))
+	for method, c := range s.counts {
+		codes := make(map[Code]uint64, len(c.Codes))
+		for code, n := range c.Codes {
+			codes[code] = n
+		}
+		snapshot[method] = CallCount{Calls: c.Calls, Failures: c.Failures, Codes: codes}
+	}
+	return snapshot
+}
@@ -0,0 +1,170 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRetryInterceptor 测试只有幂等方法在Unavailable时才会被重试.
+func TestRetryInterceptor(t *testing.T) {
+	retry := RetryInterceptor(3, time.Millisecond, 2*time.Millisecond)
+	broken := unavailable(ErrConnBroken)
+
+	var tests = []struct {
+		idempotent bool
+		errs       []error // 每次调用invoker返回的错误, 用完后返回nil.
+		calls      int
+		code       Code
+	}{
+		{true, nil, 1, OK},
+		{true, []error{broken}, 2, OK},
+		{true, []error{broken, broken, broken}, 3, Unavailable},
+		{true, []error{Errorf(NotFound, "no such user")}, 1, NotFound},
+		{false, []error{broken}, 1, Unavailable},
+	}
+	for _, test := range tests {
+		calls := 0
+		invoker := func(ctx context.Context, req interface{}, resp interface{}) error {
+			calls++
+			if calls <= len(test.errs) {
+				return test.errs[calls-1]
+			}
+			return nil
+		}
+		info := &CallInfo{Method: "User.GetProfile", Idempotent: test.idempotent}
+		err := retry(context.Background(), info, nil, nil, invoker)
+		if calls != test.calls || CodeOf(err) != test.code {
+			t.Errorf("retry didn't pass. idempotent:%v, errs:%d, calls:%d, err:%v", test.idempotent, len(test.errs), calls, err)
+		}
+	}
+}
+
+// TestClientInterceptor 测试客户端拦截器的执行顺序和调用统计.
+func TestClientInterceptor(t *testing.T) {
+	client := startServer(t)
+	var trace []string
+	record := func(name string) ClientInterceptor {
+		return func(ctx context.Context, info *CallInfo, req interface{}, resp interface{}, invoker Invoker) error {
+			trace = append(trace, name+" "+info.Method)
+			return invoker(ctx, req, resp)
+		}
+	}
+	var stats CallStats
+	client.Use(record("first"), stats.Interceptor, record("second"))
+	client.Idempotent("Greeter.Hello")
+
+	var resp echoResp
+	if err := client.Call("Greeter.Hello", echoReq{Msg: "bot1"}, &resp); err != nil || resp.Msg != "hello bot1" {
+		t.Errorf("Call didn't pass. resp:%v, err:%v", resp, err)
+	}
+	if got := strings.Join(trace, ","); got != "first Greeter.Hello,second Greeter.Hello" {
+		t.Errorf("interceptor order didn't pass. trace:%s", got)
+	}
+	if err := client.Call("Greeter.Fail", echoReq{Msg: "bot1"}, &resp); CodeOf(err) != NotFound {
+		t.Errorf("Call didn't pass. err:%v", err)
+	}
+
+	snapshot := stats.Snapshot()
+	if c := snapshot["Greeter.Hello"]; c.Calls != 1 || c.Failures != 0 {
+		t.Errorf("stats didn't pass. Greeter.Hello:%+v", c)
+	}
+	if c := snapshot["Greeter.Fail"]; c.Calls != 1 || c.Failures != 1 || c.Codes[NotFound] != 1 {
+		t.Errorf("stats didn't pass. Greeter.Fail:%+v", c)
+	}
+}
+
+// TestCircuitBreaker 测试熔断器在关闭、打开、半开之间的转换.
+func TestCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(2, 20*time.Millisecond)
+	broken := unavailable(ErrConnBroken)
+
+	var tests = []struct {
+		wait  time.Duration // 调用前等待的时间.
+		err   error         // invoker返回的错误.
+		call  bool          // invoker是否被调用.
+		state string        // 调用后的状态.
+	}{
+		{0, broken, true, "closed"},
+		{0, Errorf(NotFound, "no such user"), true, "closed"},
+		{0, broken, true, "closed"},
+		{0, broken, true, "open"},
+		{0, nil, false, "open"},
+		{30 * time.Millisecond, broken, true, "open"},
+		{0, nil, false, "open"},
+		{30 * time.Millisecond, nil, true, "closed"},
+	}
+	info := &CallInfo{Method: "User.GetProfile"}
+	for i, test := range tests {
+		time.Sleep(test.wait)
+		called := false
+		invoker := func(ctx context.Context, req interface{}, resp interface{}) error {
+			called = true
+			return test.err
+		}
+		err := breaker.Interceptor(context.Background(), info, nil, nil, invoker)
+		if called != test.call || !called && !errors.Is(err, ErrCircuitOpen) || breaker.State(info.Method) != test.state {
+			t.Errorf("circuit breaker didn't pass. step:%d, called:%v, state:%s, err:%v", i, called, breaker.State(info.Method), err)
+		}
+	}
+	if state := breaker.State("User.Login"); state != "closed" {
+		t.Errorf("circuit breaker of other method didn't pass. state:%s", state)
+	}
+}
+
+// TestConcurrencyLimiter 测试超过并发限制时直接拒绝, 以及限制的加性增加和乘性减少.
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter := NewConcurrencyLimiter(2, 1, 3)
+	info := &CallInfo{Method: "User.GetProfile"}
+	started := make(chan struct{}, 2)
+	var release chan struct{}
+	call := func(err error) error {
+		return limiter.Interceptor(context.Background(), info, nil, nil, func(ctx context.Context, req interface{}, resp interface{}) error {
+			if release != nil {
+				started <- struct{}{}
+				<-release
+			}
+			return err
+		})
+	}
+
+	//占满两个名额, 第三个调用被拒绝. 占用名额的调用返回NotFound, 不影响限制.
+	release = make(chan struct{})
+	done := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			call(Errorf(NotFound, "no such user"))
+			done <- struct{}{}
+		}()
+	}
+	<-started
+	<-started
+	if err := call(nil); !errors.Is(err, ErrLimitExceeded) || CodeOf(err) != Unavailable {
+		t.Errorf("limit exceeded didn't pass. err:%v", err)
+	}
+	close(release)
+	<-done
+	<-done
+	release = nil
+
+	var tests = []struct {
+		err   error
+		limit int
+	}{
+		{nil, 2},
+		{nil, 2},
+		{nil, 3},
+		{Errorf(NotFound, "no such user"), 3},
+		{unavailable(ErrConnBroken), 1},
+		{&Error{Code: DeadlineExceeded}, 1},
+		{nil, 2},
+	}
+	for i, test := range tests {
+		call(test.err)
+		if limit := limiter.Limit(); limit != test.limit {
+			t.Errorf("limit didn't pass. step:%d, limit:%d", i, limit)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrLimitExceeded 同时进行的调用超过了并发限制, 调用没有发送到服务端.
+var ErrLimitExceeded = errors.New("rpc: concurrency limit exceeded")
+
+// ConcurrencyLimiter 自适应的并发限制(AIMD): 同时进行的调用超过limit时直接返回Unavailable(ErrLimitExceeded),
+// 而不是让调用者排队等待. 调用成功时limit加性增加(约每limit次成功加1),
+// 服务端不可用、超时或超过服务端配额时limit减半, limit始终在[min, max]之间.
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    float64
+	min      float64
+	max      float64
+	inflight int
+}
+
+// NewConcurrencyLimiter 创建初始并发限制为initial的限制器, 通过Interceptor接入客户端.
+func NewConcurrencyLimiter(initial, min, max int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{limit: float64(initial), min: float64(min), max: float64(max)}
+}
+
+// Interceptor 并发限制的客户端拦截器.
+func (l *ConcurrencyLimiter) Interceptor(ctx context.Context, info *CallInfo, req interface{}, resp interface{}, invoker Invoker) error {
+	if !l.acquire() {
+		return unavailable(ErrLimitExceeded)
+	}
+	err := invoker(ctx, req, resp)
+	l.release(err)
+	return err
+}
+
+// Limit 返回当前的并发限制.
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// acquire 占用一个并发名额, 已经达到限制时返回false.
+func (l *ConcurrencyLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight >= int(l.limit) {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// release 释放并发名额, 并根据调用结果调整限制.
+func (l *ConcurrencyLimiter) release(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	switch code := CodeOf(err); {
+	case (code == Unavailable || code == DeadlineExceeded || code == ResourceExhausted) && !rejected(err):
+		if l.limit /= 2; l.limit < l.min {
+			l.limit = l.min
+		}
+	case err == nil:
+		if l.limit += 1 / l.limit; l.limit > l.max {
+			l.limit = l.max
+		}
+	}
+}
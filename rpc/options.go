@@ -0,0 +1,124 @@
+package rpc
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// options rpc客户端和服务端共用的配置项.
+type options struct {
+	maxMessageSize    int           // 单个消息体的最大长度.
+	heartbeatInterval time.Duration // 客户端连接空闲多久后发送心跳, 0表示不发送.
+	heartbeatTimeout  time.Duration // 发送心跳后等待应答的时间.
+	redialMinBackoff  time.Duration // 客户端重连的初始间隔.
+	redialMaxBackoff  time.Duration // 客户端重连的最大间隔.
+	codec             byte          // 客户端编码请求使用的codec.
+	balance           BalancePolicy // 客户端在多个后端之间的负载均衡策略.
+	resolveInterval   time.Duration // 客户端重新发现后端的间隔.
+	tlsConfig         *tls.Config   // 不为nil时连接使用TLS.
+	batchParallelism  int           // 服务端同时处理一个批量调用中的调用的最大数量.
+	streamWindow      int           // 客户端在一个流上最多缓存的消息数.
+}
+
+// Option 用于配置rpc客户端(Client)和服务端(Server).
+type Option func(*options)
+
+// defaultOptions 返回默认配置，并应用opts.
+func defaultOptions(opts []Option) options {
+	o := options{
+		maxMessageSize:    DefaultMaxMessageSize,
+		heartbeatInterval: 10 * time.Second,
+		heartbeatTimeout:  5 * time.Second,
+		redialMinBackoff:  100 * time.Millisecond,
+		redialMaxBackoff:  10 * time.Second,
+		codec:             CodecJSON,
+		balance:           RoundRobin,
+		resolveInterval:   10 * time.Second,
+		batchParallelism:  16,
+		streamWindow:      16,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// MaxMessageSize 设置单个消息体的最大长度，超过该长度的请求或应答会被拒绝.
+func MaxMessageSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxMessageSize = n
+		}
+	}
+}
+
+// Heartbeat 设置客户端心跳: 连接空闲interval后发送心跳帧, 再经过timeout仍未收到任何数据则断开重连.
+// interval为0表示关闭心跳.
+func Heartbeat(interval, timeout time.Duration) Option {
+	return func(o *options) {
+		o.heartbeatInterval = interval
+		if timeout > 0 {
+			o.heartbeatTimeout = timeout
+		}
+	}
+}
+
+// RedialBackoff 设置客户端重连的退避间隔, 从min开始每次失败翻倍, 最大为max.
+func RedialBackoff(min, max time.Duration) Option {
+	return func(o *options) {
+		if min > 0 && max >= min {
+			o.redialMinBackoff = min
+			o.redialMaxBackoff = max
+		}
+	}
+}
+
+// UseCodec 设置客户端编码请求使用的codec(如CodecMsgpack), 服务端会用相同的codec编码应答.
+func UseCodec(id byte) Option {
+	return func(o *options) {
+		o.codec = id
+	}
+}
+
+// LoadBalance 设置客户端在多个后端之间的负载均衡策略, 默认为RoundRobin.
+func LoadBalance(policy BalancePolicy) Option {
+	return func(o *options) {
+		o.balance = policy
+	}
+}
+
+// ResolveInterval 设置客户端调用Resolver重新发现后端的间隔.
+func ResolveInterval(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.resolveInterval = d
+		}
+	}
+}
+
+// BatchParallelism 设置服务端同时处理一个批量调用(见Batch)中的调用的最大数量, 默认为16.
+func BatchParallelism(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.batchParallelism = n
+		}
+	}
+}
+
+// StreamWindow 设置流式调用(见Stream)的流量控制窗口: 客户端在一个流上最多缓存n个未读取的消息,
+// 服务端发送n个消息后要等客户端读取了一部分才能继续发送. 默认为16.
+func StreamWindow(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.streamWindow = n
+		}
+	}
+}
+
+// TLS 设置客户端和服务端使用TLS加密连接, 客户端和服务端需要同时开启.
+// 双向认证以及不重启更新证书见CertReloader.
+func TLS(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
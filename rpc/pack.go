@@ -1,15 +1,87 @@
 package rpc
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"strconv"
+	"time"
 )
 
-// PackMaxSize tcp包header最大size.
+// PackMaxSize 旧版(ASCII)tcp包header最大size.
 const PackMaxSize int = 4
 
-//pack 对类型v进行json封装，将封装后的json长度和json数据，拼接为字符数组并返回.
+/*
+	二进制帧格式(所有整数均为大端序):
+
+	+-------+---------+------+--------+-------+----------+--------------+---------+----------------+
+	| magic | version | type | status | codec | id       | timeout      | nameLen | length(uint32) |
+	+-------+---------+------+--------+-------+----------+--------------+---------+----------------+
+	   1B       1B      1B      1B       1B      8B           8B            2B           4B
+
+	header之后是nameLen个字节的方法名和length个字节的body.
+
+	type区分请求帧、应答帧、心跳帧、goaway帧、批量请求帧和流式调用的各种帧, id由客户端为每个请求分配, 服务端原样带回,
+	因此同一个连接上可以同时存在多个未完成的请求, 应答也可以乱序返回.
+	timeout是请求剩余的处理时间(纳秒), 0表示没有截止时间, 服务端据此为请求创建带超时的context.
+	status是应答的状态码(见Code), 不为OK时body是错误信息而不是应答数据.
+	codec是body的编码方式(content-type, 见Codec), 服务端用与请求相同的codec编码应答.
+	流式调用以window帧做流量控制: 服务端只有在客户端给出额度时才能发送消息帧, 读取慢的客户端不会让服务端堆积消息.
+	方法名只在请求帧中出现, 单独存放使得body可以直接交给codec解码, 不需要再套一层封装.
+
+	旧版帧的header是4位ASCII数字(见pack), 首字节一定是'0'~'9',
+	而magic不在该范围内, 服务端据此判断连接使用的是哪一种帧格式.
+*/
+
+const (
+	// FrameMagic 二进制帧的魔数.
+	FrameMagic byte = 0xB7
+	// FrameVersion 当前二进制帧的版本号.
+	FrameVersion byte = 1
+	// DefaultMaxMessageSize 默认单个消息体的最大长度(4MB).
+	DefaultMaxMessageSize int = 4 << 20
+
+	// frameHeaderSize 二进制帧header的长度.
+	frameHeaderSize int = 27
+)
+
+// 帧类型.
+const (
+	frameRequest   byte = iota + 1 // 请求帧.
+	frameResponse                  // 应答帧.
+	framePing                      // 心跳帧, 由客户端在连接空闲时发送.
+	framePong                      // 心跳应答帧.
+	frameGoAway                    // 服务端即将关闭, 客户端不要再在该连接上发送新的请求; 客户端回复goaway帧确认.
+	frameBatch                     // 批量请求帧, body中包含多个请求帧(见packBatch), 应答帧的body中包含对应的多个应答帧.
+	frameStream                    // 流式请求帧, 服务端以若干个消息帧和一个结束帧应答.
+	frameStreamMsg                 // 流式调用的一个消息.
+	frameStreamEnd                 // 流式调用结束, status不为OK时body是错误信息.
+	frameWindow                    // 客户端允许服务端在流上再发送的消息数(body为uint32).
+	frameCancel                    // 客户端不再读取流, 服务端取消流式方法的ctx.
+)
+
+// frame 一个二进制帧.
+type frame struct {
+	typ     byte
+	status  Code
+	codec   byte
+	id      uint64
+	timeout time.Duration
+	name    string
+	body    []byte
+}
+
+var (
+	// ErrBadMagic 帧的魔数不正确.
+	ErrBadMagic = errors.New("rpc: bad frame magic")
+	// ErrMessageTooLarge 消息体超过了允许的最大长度.
+	ErrMessageTooLarge = errors.New("rpc: message is too large")
+)
+
+//pack 对类型v进行json封装，将封装后的json长度和json数据，拼接为字符数组并返回(旧版ASCII帧).
 func pack(v interface{}) ([]byte, error) {
 	//对v类型使用json封装
 	rspBytes, err := json.Marshal(v)
@@ -43,3 +115,81 @@ func pack(v interface{}) ([]byte, error) {
 
 	return tb, nil
 }
+
+// unpack 从r中读取一个旧版ASCII帧，返回其body.
+func unpack(r io.Reader) ([]byte, error) {
+	dataLen := make([]byte, PackMaxSize)
+	if _, err := io.ReadFull(r, dataLen); err != nil {
+		return nil, err
+	}
+	len, err := strconv.ParseInt(string(dataLen), 10, 64)
+	if err != nil || len < 0 {
+		return nil, fmt.Errorf("rpc: bad legacy header %q", dataLen)
+	}
+	buff := make([]byte, len)
+	if _, err := io.ReadFull(r, buff); err != nil {
+		return nil, err
+	}
+	return buff, nil
+}
+
+// packFrame 为f加上二进制帧header，返回可以直接写入连接的字符数组.
+func packFrame(f frame, maxSize int) ([]byte, error) {
+	if len(f.name) > math.MaxUint16 {
+		return nil, errors.New("rpc: method name is too long")
+	}
+	if len(f.name)+len(f.body) > maxSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	tb := make([]byte, frameHeaderSize+len(f.name)+len(f.body))
+	tb[0] = FrameMagic
+	tb[1] = FrameVersion
+	tb[2] = f.typ
+	tb[3] = byte(f.status)
+	tb[4] = f.codec
+	binary.BigEndian.PutUint64(tb[5:13], f.id)
+	binary.BigEndian.PutUint64(tb[13:21], uint64(f.timeout))
+	binary.BigEndian.PutUint16(tb[21:23], uint16(len(f.name)))
+	binary.BigEndian.PutUint32(tb[23:frameHeaderSize], uint32(len(f.body)))
+	n := copy(tb[frameHeaderSize:], f.name)
+	copy(tb[frameHeaderSize+n:], f.body)
+	return tb, nil
+}
+
+// readFrame 从r中读取一个完整的二进制帧. header和body均使用io.ReadFull读取，避免半包破坏数据流.
+func readFrame(r io.Reader, maxSize int) (frame, error) {
+	header := make([]byte, frameHeaderSize)
+	//先检查魔数再读取header的其余部分, 旧版服务端的ASCII帧比header短, 一起读取会一直等待.
+	if _, err := io.ReadFull(r, header[:1]); err != nil {
+		return frame{}, err
+	}
+	if header[0] != FrameMagic {
+		return frame{}, ErrBadMagic
+	}
+	if _, err := io.ReadFull(r, header[1:]); err != nil {
+		return frame{}, err
+	}
+	if header[1] != FrameVersion {
+		return frame{}, fmt.Errorf("rpc: unsupported frame version %d", header[1])
+	}
+	nameLen := int(binary.BigEndian.Uint16(header[21:23]))
+	len := binary.BigEndian.Uint32(header[23:frameHeaderSize])
+	if uint64(nameLen)+uint64(len) > uint64(maxSize) {
+		return frame{}, ErrMessageTooLarge
+	}
+
+	buff := make([]byte, nameLen+int(len))
+	if _, err := io.ReadFull(r, buff); err != nil {
+		return frame{}, err
+	}
+	return frame{
+		typ:     header[2],
+		status:  Code(header[3]),
+		codec:   header[4],
+		id:      binary.BigEndian.Uint64(header[5:13]),
+		timeout: time.Duration(binary.BigEndian.Uint64(header[13:21])),
+		name:    string(buff[:nameLen]),
+		body:    buff[nameLen:],
+	}, nil
+}
@@ -0,0 +1,84 @@
+package rpc
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPackFrame 测试二进制帧的封装与读取, 包括超过旧版9999字节限制的消息.
+func TestPackFrame(t *testing.T) {
+	var tests = []struct {
+		data    string
+		maxSize int
+		ok      bool
+	}{
+		{"hello", DefaultMaxMessageSize, true},
+		{strings.Repeat("a", 20000), DefaultMaxMessageSize, true},
+		{strings.Repeat("a", 20000), 1024, false},
+	}
+	for _, test := range tests {
+		f := frame{typ: frameRequest, status: NotFound, codec: CodecJSON, id: 7, timeout: time.Second, name: "User.Login", body: []byte(test.data)}
+		b, err := packFrame(f, test.maxSize)
+		if (err == nil) != test.ok {
+			t.Errorf("packFrame didn't pass. len:%d, maxSize:%d, err:%v", len(test.data), test.maxSize, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if b[0] != FrameMagic || b[1] != FrameVersion {
+			t.Errorf("packFrame bad header. header:%v", b[:frameHeaderSize])
+		}
+		got, err := readFrame(bytes.NewReader(b), test.maxSize)
+		if err != nil || !reflect.DeepEqual(got, f) {
+			t.Errorf("readFrame didn't pass. len:%d, err:%v", len(test.data), err)
+		}
+	}
+}
+
+// TestReadFrame 测试readFrame对非法帧的处理.
+func TestReadFrame(t *testing.T) {
+	good, err := packFrame(frame{typ: frameRequest, id: 1, body: []byte("{}")}, DefaultMaxMessageSize)
+	if err != nil {
+		t.Fatalf("packFrame failed. err:%v", err)
+	}
+	// modify 复制good并修改第i个字节.
+	modify := func(i int, b byte) []byte {
+		f := append([]byte(nil), good...)
+		f[i] = b
+		return f
+	}
+
+	var tests = []struct {
+		frame   []byte
+		maxSize int
+		err     bool
+	}{
+		{good, DefaultMaxMessageSize, false},
+		{modify(0, '0'), DefaultMaxMessageSize, true},
+		{modify(1, 99), DefaultMaxMessageSize, true},
+		{good[:len(good)-1], DefaultMaxMessageSize, true},
+		{good[:frameHeaderSize-1], DefaultMaxMessageSize, true},
+		{good, 1, true},
+	}
+	for _, test := range tests {
+		if _, err := readFrame(bytes.NewReader(test.frame), test.maxSize); (err != nil) != test.err {
+			t.Errorf("readFrame didn't pass. frame:%v, err:%v", test.frame, err)
+		}
+	}
+}
+
+// TestUnpack 测试旧版ASCII帧的读取.
+func TestUnpack(t *testing.T) {
+	b, err := pack("hello")
+	if err != nil {
+		t.Fatalf("pack failed. err:%v", err)
+	}
+	body, err := unpack(bytes.NewReader(b))
+	if err != nil || string(body) != `"hello"` {
+		t.Errorf("unpack didn't pass. body:%s, err:%v", body, err)
+	}
+}
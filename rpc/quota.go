@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Quota 服务端一个方法的配额.
+type Quota struct {
+	MaxConcurrent int     // 同时执行的最大数量, 0表示不限制.
+	MaxQueue      int     // 达到MaxConcurrent后最多排队等待的请求数, 超过时拒绝.
+	Rate          float64 // 每秒允许的请求数(令牌桶), 0表示不限制.
+	Burst         int     // 令牌桶的容量, 即允许的突发请求数, 0表示与Rate相同(至少为1).
+}
+
+// QuotaStats 一个方法的配额使用情况.
+type QuotaStats struct {
+	Running  int64  // 正在执行的请求数.
+	Queued   int64  // 正在排队等待的请求数(队列深度).
+	Rejected uint64 // 因超过配额被拒绝的请求数.
+}
+
+// Quotas 服务端按方法的并发和速率配额: 请求先从令牌桶中取令牌, 取不到时直接拒绝;
+// 再占用一个并发名额, 没有名额时排队等待, 队列已满时拒绝. 被拒绝的请求返回ResourceExhausted.
+// 用于防止某个方法(如写数据库的SignUp)的突发流量占满服务端, 影响其他方法.
+//
+//	quotas := rpc.NewQuotas(map[string]rpc.Quota{"User.SignUp": {MaxConcurrent: 50, MaxQueue: 100, Rate: 200}})
+//	server.Use(quotas.Interceptor)
+type Quotas struct {
+	methods map[string]*methodQuota
+}
+
+// methodQuota 一个方法的配额状态.
+type methodQuota struct {
+	running  int64
+	queued   int64
+	rejected uint64
+
+	quota Quota
+	slots chan struct{} // 并发名额, MaxConcurrent为0时为nil.
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64   // 令牌桶中的令牌数.
+	last   time.Time // 上次补充令牌的时间.
+}
+
+// NewQuotas 创建配额, quotas为方法名到配额的映射, 没有配置的方法不受限制. 通过Interceptor接入服务端.
+func NewQuotas(quotas map[string]Quota) *Quotas {
+	q := &Quotas{methods: make(map[string]*methodQuota, len(quotas))}
+	for method, quota := range quotas {
+		m := &methodQuota{quota: quota, burst: float64(quota.Burst), last: time.Now()}
+		if m.burst <= 0 {
+			m.burst = math.Max(1, quota.Rate)
+		}
+		m.tokens = m.burst
+		if quota.MaxConcurrent > 0 {
+			m.slots = make(chan struct{}, quota.MaxConcurrent)
+		}
+		q.methods[method] = m
+	}
+	return q
+}
+
+// Interceptor 配额的服务端拦截器.
+func (q *Quotas) Interceptor(ctx context.Context, info *ServerInfo, req interface{}, next Handler) (interface{}, error) {
+	m, ok := q.methods[info.Method]
+	if !ok {
+		return next(ctx, req)
+	}
+	if err := m.acquire(ctx, info.Method); err != nil {
+		return nil, err
+	}
+	defer m.release()
+	return next(ctx, req)
+}
+
+// Stats 返回每个配置了配额的方法的使用情况.
+func (q *Quotas) Stats() map[string]QuotaStats {
+	stats := make(map[string]QuotaStats, len(q.methods))
+	for method, m := range q.methods {
+		stats[method] = QuotaStats{
+			Running:  atomic.LoadInt64(&m.running),
+			Queued:   atomic.LoadInt64(&m.queued),
+			Rejected: atomic.LoadUint64(&m.rejected),
+		}
+	}
+	return stats
+}
+
+// acquire 取令牌并占用并发名额, 超过配额时返回ResourceExhausted, 排队时ctx结束返回ctx的错误.
+func (m *methodQuota) acquire(ctx context.Context, method string) error {
+	if !m.take() {
+		atomic.AddUint64(&m.rejected, 1)
+		return Errorf(ResourceExhausted, "%s: rate limit exceeded", method)
+	}
+	if m.slots == nil {
+		atomic.AddInt64(&m.running, 1)
+		return nil
+	}
+	select {
+	case m.slots <- struct{}{}:
+		atomic.AddInt64(&m.running, 1)
+		return nil
+	default:
+	}
+
+	if atomic.AddInt64(&m.queued, 1) > int64(m.quota.MaxQueue) {
+		atomic.AddInt64(&m.queued, -1)
+		atomic.AddUint64(&m.rejected, 1)
+		return Errorf(ResourceExhausted, "%s: too many concurrent requests", method)
+	}
+	defer atomic.AddInt64(&m.queued, -1)
+	select {
+	case m.slots <- struct{}{}:
+		atomic.AddInt64(&m.running, 1)
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx.Err())
+	}
+}
+
+// release 释放并发名额.
+func (m *methodQuota) release() {
+	atomic.AddInt64(&m.running, -1)
+	if m.slots != nil {
+		<-m.slots
+	}
+}
+
+// take 从令牌桶中取一个令牌, 没有令牌时返回false.
+func (m *methodQuota) take() bool {
+	if m.quota.Rate <= 0 {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.tokens = math.Min(m.burst, m.tokens+now.Sub(m.last).Seconds()*m.quota.Rate)
+	m.last = now
+	if m.tokens < 1 {
+		return false
+	}
+	m.tokens--
+	return true
+}
@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestQuotas 测试服务端按方法的并发配额(排队和拒绝)和速率配额, 以及没有配置配额的方法不受影响.
+func TestQuotas(t *testing.T) {
+	server := newTestServer(t)
+	quotas := NewQuotas(map[string]Quota{
+		"Echo":          {MaxConcurrent: 1, MaxQueue: 1},
+		"Greeter.Hello": {Rate: 10, Burst: 2},
+	})
+	server.Use(quotas.Interceptor)
+	client := serve(t, server)
+
+	//同时调用3次: 一个执行, 一个排队, 一个被拒绝.
+	var wg sync.WaitGroup
+	codes := make(chan Code, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- CodeOf(client.Call("Echo", echoReq{Msg: "hello", Sleep: 200}, &echoResp{}))
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if stats := quotas.Stats()["Echo"]; stats != (QuotaStats{Running: 1, Queued: 1, Rejected: 1}) {
+		t.Errorf("Quotas stats didn't pass. stats:%+v", stats)
+	}
+	wg.Wait()
+	close(codes)
+	count := make(map[Code]int)
+	for code := range codes {
+		count[code]++
+	}
+	if count[OK] != 2 || count[ResourceExhausted] != 1 {
+		t.Errorf("Quotas concurrency didn't pass. codes:%v", count)
+	}
+
+	//排队时超过截止时间返回DeadlineExceeded.
+	go client.Call("Echo", echoReq{Msg: "hello", Sleep: 200}, &echoResp{})
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.CallContext(ctx, "Echo", echoReq{Msg: "hello"}, &echoResp{}); CodeOf(err) != DeadlineExceeded {
+		t.Errorf("Quotas queue timeout didn't pass. err:%v", err)
+	}
+
+	var tests = []struct {
+		name  string
+		sleep time.Duration
+		code  Code
+	}{
+		{"Greeter.Hello", 0, OK},
+		{"Greeter.Hello", 0, OK},
+		{"Greeter.Hello", 0, ResourceExhausted},
+		{"Greeter.Hello", 150 * time.Millisecond, OK},
+		{"Greeter.Fail", 0, NotFound},
+	}
+	for _, test := range tests {
+		time.Sleep(test.sleep)
+		if err := client.Call(test.name, echoReq{Msg: "bot1"}, &echoResp{}); CodeOf(err) != test.code {
+			t.Errorf("Quotas rate didn't pass. name:%s, code:%s, err:%v", test.name, test.code, err)
+		}
+	}
+}
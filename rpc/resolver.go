@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Resolver 服务发现, 返回当前所有rpc服务端(后端)的地址(见ParseAddr).
+// 客户端每隔ResolveInterval调用一次Resolve, 连接新增的后端并关闭已经移除的后端.
+type Resolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// StaticResolver 固定的后端地址列表.
+type StaticResolver []string
+
+// Resolve 实现Resolver.
+func (s StaticResolver) Resolve(ctx context.Context) ([]string, error) {
+	return s, nil
+}
+
+// FileResolver 从文件中读取后端地址, 每行一个地址, 空行和以#开头的行会被忽略.
+// 客户端定期重新读取该文件, 修改文件即可增删后端.
+type FileResolver string
+
+// Resolve 实现Resolver.
+func (f FileResolver) Resolve(ctx context.Context) ([]string, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var addrs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, scanner.Err()
+}
+
+// SRVResolver 通过DNS SRV记录(_Service._Proto.Name)发现后端, eg:_usermana._tcp.example.com.
+type SRVResolver struct {
+	Service string
+	Proto   string
+	Name    string
+}
+
+// Resolve 实现Resolver.
+func (s SRVResolver) Resolve(ctx context.Context) ([]string, error) {
+	_, srvs, err := net.DefaultResolver.LookupSRV(ctx, s.Service, s.Proto, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(srvs))
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		addrs = append(addrs, "tcp://"+net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+	}
+	return addrs, nil
+}
@@ -1,47 +1,194 @@
 package rpc
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net"
+	"os"
 	"reflect"
-	"strconv"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 	"usermana/log"
 )
 
-//serverFunc 处理实际请求的函数.
-type serverFunc func(interface{}) interface{}
+// ErrServerClosed Shutdown之后ListenAndServe返回的错误.
+var ErrServerClosed = errors.New("rpc: server closed")
 
-//request 对应rpc客户端请求的数据.
+//serverFunc 处理实际请求的函数, ctx在客户端设置的截止时间到达时结束.
+type serverFunc func(context.Context, interface{}) interface{}
+
+// Handler 统一后的处理函数, req为参数结构体的指针. 服务函数返回的error会作为应答的状态码返回给客户端.
+type Handler func(ctx context.Context, req interface{}) (interface{}, error)
+
+// ServerInfo 拦截器可以获取的请求信息.
+type ServerInfo struct {
+	Method    string       // 方法名, eg:User.Login.
+	ReplyType reflect.Type // 返回值的类型, 流式方法为消息的类型.
+	Stream    bool         // 是否是流式方法(见RegisterService).
+}
+
+// ServerInterceptor 服务端拦截器, 可以在调用next前后加入鉴权、日志、统计等通用逻辑, 也可以不调用next直接返回.
+type ServerInterceptor func(ctx context.Context, info *ServerInfo, req interface{}, next Handler) (interface{}, error)
+
+var (
+	// contextType context.Context接口的类型, 用于检查服务函数的第一个参数.
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	// errorType error接口的类型, 用于检查服务函数的第二个返回值.
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+//request 对应旧版rpc客户端请求的数据. 二进制帧中方法名单独存放, 不再使用此结构.
 type request struct {
 	Name string `json:"name"`
 	Data []byte `json:"data"`
 }
 
 type rpcHandler struct {
-	handler    serverFunc
-	argsType   reflect.Type //handler函数的参数类型.
-	replysType reflect.Type //handler函数的返回值类型.
+	handler    Handler
+	stream     streamHandler //流式方法的处理函数, 不为nil时handler为nil.
+	argsType   reflect.Type  //handler函数的参数类型.
+	replysType reflect.Type  //handler函数的返回值类型(流式方法为消息类型).
 }
 
 // RPCServer 维护函数名以及函数具柄的map集合.
 type RPCServer struct {
-	router map[string]rpcHandler
+	active int64 // 正在处理的请求数, 原子读写, 放在首位保证64位对齐.
+
+	router       map[string]rpcHandler
+	interceptors []ServerInterceptor
+	opts         options
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[*serverConn]struct{}
+	inShutdown bool
+}
+
+// serverConn 服务端的一个连接.
+type serverConn struct {
+	conn net.Conn
+	wmu  sync.Mutex // 保证一个帧完整地写入连接.
+	// frames 连接使用二进制帧, 可以发送goaway帧. legacy 连接使用旧版ASCII帧, 只能在处理完请求后直接关闭.
+	// 两者都为false时还没有读到连接的第一个字节. 由RPCServer.mu保护.
+	frames, legacy bool
+	// goAway 已经发送了goaway帧, 还没有收到客户端的确认. 由RPCServer.mu保护.
+	goAway bool
+
+	smu     sync.Mutex
+	streams map[uint64]*serverStream // 请求id -> 正在进行的流式调用.
+}
+
+// write 将b完整地写入连接.
+func (sc *serverConn) write(b []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	_, err := sc.conn.Write(b)
+	return err
 }
 
 //Server 初始化并返回一个rpc服务端.
-func Server() RPCServer {
-	return RPCServer{make(map[string]rpcHandler)}
+//服务端内置DescribeMethod, 客户端可以通过它查询注册的方法.
+func Server(opts ...Option) *RPCServer {
+	r := &RPCServer{
+		router:    make(map[string]rpcHandler),
+		opts:      defaultOptions(opts),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*serverConn]struct{}),
+	}
+	r.registerDescribe()
+	return r
 }
 
 //Register 注册服务端方法，服务端需实现两个函数，其中handler用于获取句柄，service用于获取实际参数类型.
+//service的形式为func(context.Context, Req) Resp, Req和Resp都必须是结构体.
 func (r *RPCServer) Register(name string, handler serverFunc, service interface{}) error {
 	return r.register(name, handler, service)
 }
 
-// ListenAndServe 服务端开始监听并响应请求.
+// RegisterService 通过反射注册rcvr的所有导出方法, 方法名为"类型名.方法名"(与net/rpc相同), eg:User.Login.
+// 方法的形式为func(context.Context, Req) (Resp, error), 不符合该形式的方法会被忽略.
+// Req和Resp可以是结构体或结构体指针, 使用CodecProtobuf时必须是protoc生成的消息指针, eg:*pb.LoginRequest.
+// 形式为func(context.Context, Req, func(Msg) error) error的方法注册为流式方法, 客户端通过Stream调用,
+// 方法每调用一次send就向客户端发送一个消息, 返回后流结束; 客户端读取太慢时send会阻塞(见StreamWindow).
+func (r *RPCServer) RegisterService(rcvr interface{}) error {
+	sname := reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+	if sname == "" {
+		return errors.New("rpc.RegisterService: no service name for type " + reflect.TypeOf(rcvr).String())
+	}
+	return r.RegisterServiceName(sname, rcvr)
+}
+
+// RegisterServiceName 与RegisterService相同, 但使用sname代替类型名, 方法名为"sname.方法名".
+// 可以用于在测试中注册实现了相同方法的假服务.
+func (r *RPCServer) RegisterServiceName(sname string, rcvr interface{}) error {
+	rcvrType := reflect.TypeOf(rcvr)
+	rcvrValue := reflect.ValueOf(rcvr)
+
+	registered := 0
+	for i := 0; i < rcvrType.NumMethod(); i++ {
+		method := rcvrType.Method(i)
+		//方法绑定接收者之后的函数, 类型中不再包含接收者参数.
+		fn := rcvrValue.Method(i)
+		if method.IsExported() && checkStreamType(fn.Type()) == nil {
+			r.router[sname+"."+method.Name] = rpcHandler{
+				stream:     reflectStreamHandler(fn),
+				argsType:   fn.Type().In(1),
+				replysType: fn.Type().In(2).In(0),
+			}
+			registered++
+			continue
+		}
+		if !method.IsExported() || r.checkHandlerType(fn.Type()) != nil || fn.Type().NumOut() != 2 {
+			continue
+		}
+		r.router[sname+"."+method.Name] = rpcHandler{
+			handler:    reflectHandler(fn),
+			argsType:   fn.Type().In(1),
+			replysType: fn.Type().Out(0),
+		}
+		registered++
+	}
+	if registered == 0 {
+		return errors.New("rpc.RegisterService: type " + sname + " has no exported methods of suitable type")
+	}
+	return nil
+}
+
+// reflectHandler 将形如func(context.Context, Req) (Resp, error)的函数包装为Handler.
+func reflectHandler(fn reflect.Value) Handler {
+	return func(ctx context.Context, args interface{}) (interface{}, error) {
+		out := fn.Call([]reflect.Value{reflect.ValueOf(ctx), argValue(fn.Type().In(1), args)})
+		if err, _ := out[1].Interface().(error); err != nil {
+			return nil, err
+		}
+		return out[0].Interface(), nil
+	}
+}
+
+// Use 注册服务端拦截器, 按注册顺序由外到内包裹服务函数. 需要在ListenAndServe之前调用.
+func (r *RPCServer) Use(interceptors ...ServerInterceptor) {
+	r.interceptors = append(r.interceptors, interceptors...)
+}
+
+// chain 将拦截器和服务函数h组合成一个Handler.
+func (r *RPCServer) chain(info *ServerInfo, h Handler) Handler {
+	for i := len(r.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := r.interceptors[i], h
+		h = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, info, req, next)
+		}
+	}
+	return h
+}
+
+// ListenAndServe 服务端开始监听address(见ParseAddr)并响应请求. 调用Shutdown之后返回ErrServerClosed.
 func (r *RPCServer) ListenAndServe(address string) error {
 	//监听.
 	listener, err := r.listen(address)
@@ -66,10 +213,13 @@ func (r *RPCServer) register(name string, handler serverFunc, service interface{
 		return err
 	}
 	//获取handleType的参数以及返回值类型.
-	argsType := serviceType.In(0)
+	argsType := serviceType.In(1)
 	replysType := serviceType.Out(0)
 	//将对应的[name,rpcHandler]保存起来.
-	r.router[name] = rpcHandler{handler: handler, argsType: argsType, replysType: replysType}
+	h := func(ctx context.Context, args interface{}) (interface{}, error) {
+		return handler(ctx, args), nil
+	}
+	r.router[name] = rpcHandler{handler: h, argsType: argsType, replysType: replysType}
 	return nil
 }
 
@@ -80,114 +230,498 @@ func (r *RPCServer) checkHandlerType(handlerType reflect.Type) error {
 		return errors.New("rpc.Register: handler is not func")
 	}
 	// 判断参数数量.
-	if handlerType.NumIn() != 1 {
-		return errors.New("rpc.Register: handler input parameters number is wrong, need one")
+	if handlerType.NumIn() != 2 {
+		return errors.New("rpc.Register: handler input parameters number is wrong, need two")
+	}
+	// 第一个参数必须是context.Context.
+	if handlerType.In(0) != contextType {
+		return errors.New("rpc.Register: handler first parameter must be context.Context")
+	}
+	// 判断返回值数量, 可以额外返回一个error.
+	if handlerType.NumOut() != 1 && handlerType.NumOut() != 2 {
+		return errors.New("rpc.Register: handler output parameters number is wrong, need one or two")
 	}
-	// 判断返回值数量.
-	if handlerType.NumOut() != 1 {
-		return errors.New("rpc.Register: handler output parameters number is wrong, need one")
+	if handlerType.NumOut() == 2 && handlerType.Out(1) != errorType {
+		return errors.New("rpc.Register: handler second output parameter must be error")
 	}
 	// 判断参数和返回值类型.
-	if handlerType.In(0).Kind() != reflect.Struct || handlerType.Out(0).Kind() != reflect.Struct {
-		return errors.New("rpc.Register: parameters must be Struct")
+	if !isStructOrPtr(handlerType.In(1)) || !isStructOrPtr(handlerType.Out(0)) {
+		return errors.New("rpc.Register: parameters must be Struct or pointer to Struct")
 	}
 	return nil
 }
 
-//listen 监听address，并返回对应的具柄.
-func (r *RPCServer) listen(address string) (*net.TCPListener, error) {
-	laddr, err := net.ResolveTCPAddr("tcp4", address)
-	if err != nil {
-		return nil, err
+// isStructOrPtr 判断t是否是结构体或结构体指针. protoc生成的消息包含锁, 只能以指针传递.
+func isStructOrPtr(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
+	return t.Kind() == reflect.Struct
+}
 
-	listener, err := net.ListenTCP("tcp4", laddr)
+// newArgs 分配argsType类型参数的存储, 返回其指针. argsType本身是指针时分配其指向的结构体.
+func newArgs(argsType reflect.Type) interface{} {
+	if argsType.Kind() == reflect.Ptr {
+		return reflect.New(argsType.Elem()).Interface()
+	}
+	return reflect.New(argsType).Interface()
+}
+
+// argValue 将newArgs分配的参数转换为服务函数需要的argsType类型.
+func argValue(argsType reflect.Type, args interface{}) reflect.Value {
+	if argsType.Kind() == reflect.Ptr {
+		return reflect.ValueOf(args)
+	}
+	return reflect.ValueOf(args).Elem()
+}
+
+//listen 监听address(见ParseAddr)，并返回对应的具柄.
+func (r *RPCServer) listen(address string) (net.Listener, error) {
+	network, addr, err := ParseAddr(address)
 	if err != nil {
 		return nil, err
 	}
-	return listener, nil
+	if network == "unix" {
+		//进程异常退出时socket文件不会被删除, 需要先删除才能重新监听.
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(addr)
+		}
+	}
+	return net.Listen(network, addr)
 }
 
 //accept 接受新的连接 启动协程RPCServer.handle去处理.
-func (r *RPCServer) accept(listener *net.TCPListener) error {
+func (r *RPCServer) accept(listener net.Listener) error {
 	defer listener.Close()
+	r.mu.Lock()
+	if r.inShutdown {
+		r.mu.Unlock()
+		return ErrServerClosed
+	}
+	r.listeners[listener] = struct{}{}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.listeners, listener)
+		r.mu.Unlock()
+	}()
+
 	for {
-		conn, err := listener.AcceptTCP()
+		conn, err := listener.Accept()
 		if err != nil {
+			if r.shuttingDown() {
+				return ErrServerClosed
+			}
 			return err
 		}
-		defer conn.Close()
-		//启动协程 处理新的连接的业务逻辑
-		go r.handle(conn)
+		//启动协程 处理新的连接的业务逻辑, 连接由handle负责关闭.
+		//TLS握手在handle中进行, 不会阻塞accept.
+		if r.opts.tlsConfig != nil {
+			go r.handle(tls.Server(conn, r.opts.tlsConfig))
+		} else {
+			go r.handle(conn)
+		}
+	}
+}
+
+// Shutdown 优雅地关闭服务端: 停止监听, 通知客户端不要在现有连接上发送新的请求(goaway帧),
+// 之后读到的请求直接以Unavailable应答. 等待所有正在处理的请求完成、并且客户端都确认了goaway帧
+// (确认之后客户端不会再发送请求)后关闭所有连接. ctx结束时不再等待, 直接关闭连接并返回ctx.Err().
+func (r *RPCServer) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.inShutdown = true
+	for listener := range r.listeners {
+		listener.Close()
+	}
+	//还没有读到第一个字节的连接也发送goaway, 新版客户端收到后才会确认, 不会在关闭连接时发送新的请求.
+	var conns []*serverConn
+	for sc := range r.conns {
+		if !sc.legacy {
+			sc.goAway = true
+			conns = append(conns, sc)
+		}
+	}
+	r.mu.Unlock()
+
+	goAway, _ := packFrame(frame{typ: frameGoAway}, r.opts.maxMessageSize)
+	for _, sc := range conns {
+		sc.write(goAway)
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for !r.drained() {
+		select {
+		case <-ctx.Done():
+			r.closeConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	r.closeConns()
+	return nil
+}
+
+// drained 返回是否所有请求都已经处理完成, 并且所有使用二进制帧的连接都确认了goaway帧.
+func (r *RPCServer) drained() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if atomic.LoadInt64(&r.active) > 0 {
+		return false
+	}
+	for sc := range r.conns {
+		if sc.frames && sc.goAway {
+			return false
+		}
+	}
+	return true
+}
+
+// begin 开始处理一个请求, 已经调用了Shutdown时返回false.
+// 与Shutdown在同一个锁内检查并增加active, 保证Shutdown等待所有已经开始的请求.
+func (r *RPCServer) begin() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inShutdown {
+		return false
+	}
+	atomic.AddInt64(&r.active, 1)
+	return true
+}
+
+// shuttingDown 返回是否已经调用了Shutdown.
+func (r *RPCServer) shuttingDown() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.inShutdown
+}
+
+// closeConns 关闭所有连接, 读协程随之退出.
+func (r *RPCServer) closeConns() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for sc := range r.conns {
+		sc.conn.Close()
+	}
+}
+
+// trackConn 记录新的连接, 已经调用了Shutdown时返回false.
+func (r *RPCServer) trackConn(sc *serverConn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inShutdown {
+		return false
 	}
+	r.conns[sc] = struct{}{}
+	return true
+}
+
+// untrackConn 删除并关闭连接.
+func (r *RPCServer) untrackConn(sc *serverConn) {
+	r.mu.Lock()
+	delete(r.conns, sc)
+	r.mu.Unlock()
+	sc.conn.Close()
 }
 
 //handle 主要是读取rpc Client发过来的数据，并且将处理结果发送回去.
-func (r *RPCServer) handle(conn *net.TCPConn) {
+//根据连接的第一个字节判断客户端使用二进制帧还是旧版ASCII帧.
+func (r *RPCServer) handle(conn net.Conn) {
 	if conn == nil {
-		//log.Errorf("rpc.ListenAndServe: tcp connection is nil")
+		log.Errorf("rpc.ListenAndServe: tcp connection is nil")
+		return
 	}
-	dataLen := make([]byte, PackMaxSize)
-	for {
-		//获取包的长度保存到n.
-		n, err := conn.Read(dataLen)
-		if err != nil && err != io.EOF {
-			log.Errorf("rpc.ListenAndServer: connection read header failed. err:%q", err)
+	sc := &serverConn{conn: conn}
+	if !r.trackConn(sc) {
+		conn.Close()
+		return
+	}
+	defer r.untrackConn(sc)
+
+	//TLS握手限制在tlsHandshakeTimeout内完成, 连接后不发送数据的客户端不会一直占用连接.
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			if !r.shuttingDown() {
+				log.Errorf("rpc.ListenAndServe: tls handshake with %s failed. err:%q", conn.RemoteAddr(), err)
+			}
+			return
 		}
-		if n <= 0 {
-			log.Errorf("rpc.ListenAndServe: not data")
+		tlsConn.SetDeadline(time.Time{})
+	}
+
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		if err != io.EOF && !r.shuttingDown() {
+			log.Errorf("rpc.ListenAndServe: connection read header failed. err:%q", err)
 		}
-		len, err := strconv.ParseInt(string(dataLen[:PackMaxSize]), 10, 64)
-		if err != nil {
-			//log.Errorf("rpc.ListenAndServer: parseInt failed. err:%q", err)
+		return
+	}
+
+	switch {
+	case first[0] == FrameMagic:
+		r.mu.Lock()
+		sc.frames = true
+		r.mu.Unlock()
+		r.serveFrames(sc, reader)
+	case first[0] >= '0' && first[0] <= '9':
+		r.mu.Lock()
+		sc.legacy = true
+		goAway := sc.goAway
+		r.mu.Unlock()
+		if goAway {
+			//旧版客户端无法解析已经发送的goaway帧, 直接关闭连接.
+			return
 		}
-		//读取长度为len包的内容.
-		buff := make([]byte, len)
-		n, err = conn.Read(buff)
+		r.serveLegacy(sc, reader)
+	default:
+		log.Errorf("rpc.ListenAndServe: unknown frame header %#x from %s", first[0], conn.RemoteAddr())
+	}
+}
+
+// serveFrames 处理使用二进制帧的连接. 每个请求由单独的协程处理，处理完成后按请求id写回应答，
+// 因此慢请求不会阻塞同一连接上的其他请求.
+func (r *RPCServer) serveFrames(sc *serverConn, reader io.Reader) {
+	//连接断开后取消所有流式调用, 否则它们可能一直等待客户端的window帧.
+	defer sc.cancelStreams()
+	for {
+		//读取一个完整的请求帧.
+		req, err := readFrame(reader, r.opts.maxMessageSize)
 		if err != nil {
-			//log.Errorf("rpc.ListenAndServer: connection read body failed. err:%q", err)
+			if err != io.EOF && !r.shuttingDown() {
+				log.Errorf("rpc.ListenAndServer: connection read request failed. err:%q", err)
+			}
+			return
 		}
-		if n <= 0 {
-			//log.Errorf("rpc.ListenAndServe: body not data")
+		switch req.typ {
+		case frameRequest, frameBatch, frameStream:
+			//Shutdown等待所有已经开始的请求处理完成, 之后读到的请求不再处理.
+			if !r.begin() {
+				r.reject(sc, req)
+				continue
+			}
+			if req.typ == frameStream {
+				//流在读协程中登记, 保证之后的window帧和cancel帧能找到它.
+				go r.serveStream(sc.openStream(req, r.opts.maxMessageSize), req)
+				continue
+			}
+		case frameGoAway:
+			//客户端确认了goaway帧, 之后不会再在该连接上发送新的请求.
+			r.mu.Lock()
+			sc.goAway = false
+			r.mu.Unlock()
+			continue
+		case frameWindow, frameCancel:
+			if stream := sc.getStream(req.id); stream != nil {
+				if req.typ == frameCancel {
+					stream.cancel()
+				} else if len(req.body) == 4 {
+					stream.grant(int(binary.BigEndian.Uint32(req.body)))
+				}
+			}
+			continue
+		case framePing:
+			//心跳帧直接在读协程中应答.
+			pong, _ := packFrame(frame{typ: framePong, id: req.id}, r.opts.maxMessageSize)
+			if err := sc.write(pong); err != nil {
+				log.Errorf("rpc.ListenAndServer: connection write pong failed. err:%q", err)
+				return
+			}
+			continue
+		default:
+			log.Errorf("rpc.ListenAndServer: unexpected frame type %d", req.typ)
+			continue
 		}
 
-		//调度,处理实际的内容.
-		rsp, err := r.dispatcher(buff)
-		if err != nil {
-			//log.Errorf("rpc.ListenAndServer: dispatch failed. err:%q", err)
+		go func(req frame) {
+			defer atomic.AddInt64(&r.active, -1)
+			ctx, cancel := requestContext(req)
+			defer cancel()
+
+			var rsp frame
+			if req.typ == frameBatch {
+				rsp = r.serveBatch(ctx, req)
+			} else {
+				rsp = r.serveRequest(ctx, req)
+			}
+			rspBytes, err := packFrame(rsp, r.opts.maxMessageSize)
+			if err != nil {
+				log.Errorf("rpc.ListenAndServer: pack response failed. err:%q", err)
+				rsp.status, rsp.body = Internal, []byte(err.Error())
+				rspBytes, _ = packFrame(rsp, r.opts.maxMessageSize)
+			}
+			//将结果发送回去.
+			if err := sc.write(rspBytes); err != nil {
+				log.Errorf("rpc.ListenAndServer: connection write response failed. err:%q", err)
+			}
+		}(req)
+	}
+}
+
+// reject 以Unavailable应答Shutdown之后读到的请求帧, 客户端可以在其他服务端上重试.
+func (r *RPCServer) reject(sc *serverConn, req frame) {
+	rsp := frame{typ: frameResponse, codec: req.codec, id: req.id, status: Unavailable, body: []byte(ErrServerClosed.Error())}
+	if req.typ == frameStream {
+		rsp.typ = frameStreamEnd
+	}
+	rspBytes, _ := packFrame(rsp, r.opts.maxMessageSize)
+	if err := sc.write(rspBytes); err != nil {
+		log.Errorf("rpc.ListenAndServer: connection write response failed. err:%q", err)
+	}
+}
+
+// serveRequest 处理一个请求帧, 返回对应的应答帧.
+func (r *RPCServer) serveRequest(ctx context.Context, req frame) frame {
+	//调度,处理实际的内容, 应答使用与请求相同的codec; 失败时应答帧带上状态码和错误信息.
+	rsp := frame{typ: frameResponse, codec: req.codec, id: req.id}
+	codec, err := getCodec(req.codec)
+	if err != nil {
+		err = Errorf(BadRequest, "%v", err)
+	} else {
+		var rspData interface{}
+		if rspData, err = r.dispatcher(ctx, req.name, codec, req.body, nil); err == nil {
+			rsp.body, err = codec.Marshal(rspData)
 		}
-		//封装rsp的应答.
-		rspBytes, err := r.packResponse(rsp)
+	}
+	if err != nil {
+		e := toError(err)
+		log.Errorf("rpc.ListenAndServer: dispatch failed. code:%s, err:%q", e.Code, e.Message)
+		rsp.status, rsp.body = e.Code, []byte(e.Message)
+	}
+	return rsp
+}
+
+// serveBatch 处理批量请求帧: 最多同时处理batchParallelism个调用(客户端要求按顺序时逐个处理),
+// 全部完成后把每个调用的应答帧放在一个应答帧中返回. 单个调用失败只影响该调用的状态.
+func (r *RPCServer) serveBatch(ctx context.Context, req frame) frame {
+	rsp := frame{typ: frameResponse, codec: req.codec, id: req.id}
+	ordered, calls, err := unpackBatch(req.body, r.opts.maxMessageSize)
+	if err != nil {
+		log.Errorf("rpc.ListenAndServer: bad batch. err:%q", err)
+		rsp.status, rsp.body = BadRequest, []byte(err.Error())
+		return rsp
+	}
+
+	results := make([]frame, len(calls))
+	parallelism := r.opts.batchParallelism
+	if ordered {
+		parallelism = 1
+	}
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, call frame) {
+			defer wg.Done()
+			results[i] = r.serveRequest(ctx, call)
+			<-sem
+		}(i, call)
+	}
+	wg.Wait()
+
+	if rsp.body, err = packBatch(false, results, r.opts.maxMessageSize); err != nil {
+		rsp.status, rsp.body = Internal, []byte(err.Error())
+	}
+	return rsp
+}
+
+// requestContext 根据请求帧中客户端传来的剩余时间创建context.
+func requestContext(req frame) (context.Context, context.CancelFunc) {
+	if req.timeout > 0 {
+		return context.WithTimeout(context.Background(), req.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// serveLegacy 处理使用旧版ASCII帧的连接. 旧版协议没有请求id, 只能按顺序逐个处理.
+func (r *RPCServer) serveLegacy(sc *serverConn, reader io.Reader) {
+	for {
+		//读取一个完整的请求包.
+		buff, err := unpack(reader)
 		if err != nil {
-			//log.Errorf("rpc.ListenAndServer: pack response failed. err:%q", err)
+			if err != io.EOF && !r.shuttingDown() {
+				log.Errorf("rpc.ListenAndServer: connection read request failed. err:%q", err)
+			}
+			return
+		}
+
+		//Shutdown等待请求处理完成. 旧版协议无法通知客户端, 关闭期间不再处理新的请求, 直接关闭连接.
+		if !r.begin() {
+			return
+		}
+		err = r.serveLegacyRequest(sc, buff)
+		atomic.AddInt64(&r.active, -1)
+		if err != nil || r.shuttingDown() {
+			return
 		}
-		//将结果发送回去
-		conn.Write(rspBytes)
 	}
 }
 
-//dispatcher 查看req对应Name名字，并且找到name对应的handle处理.
-func (r *RPCServer) dispatcher(req []byte) (interface{}, error) {
-	// 解析接口名
+// serveLegacyRequest 处理一个旧版ASCII帧的请求并写回应答.
+func (r *RPCServer) serveLegacyRequest(sc *serverConn, buff []byte) error {
+	//调度,处理实际的内容. 旧版协议没有截止时间, 只支持json, 并且请求被封装在request中.
+	//旧版协议无法携带状态码, 失败时仍然返回null.
+	var rsp interface{}
 	var cReq request
-	if err := json.Unmarshal(req, &cReq); err != nil {
-		return nil, err
+	err := json.Unmarshal(buff, &cReq)
+	if err != nil {
+		err = Errorf(BadRequest, "bad request: %v", err)
+	} else {
+		rsp, err = r.dispatcher(context.Background(), cReq.Name, jsonCodec{}, cReq.Data, nil)
 	}
+	if err != nil {
+		log.Errorf("rpc.ListenAndServer: dispatch failed. err:%q", err)
+	}
+	//封装rsp的应答.
+	rspBytes, err := pack(rsp)
+	if err != nil {
+		log.Errorf("rpc.ListenAndServer: pack response failed. err:%q", err)
+		return err
+	}
+	//将结果发送回去
+	if err := sc.write(rspBytes); err != nil {
+		log.Errorf("rpc.ListenAndServer: connection write response failed. err:%q", err)
+		return err
+	}
+	return nil
+}
+
+//dispatcher 查看name对应的handle, 使用codec解析data作为参数并处理. stream不为nil时调用流式方法, 消息通过stream发送.
+//服务函数panic时记录调用栈并返回Internal, 只影响当前请求.
+func (r *RPCServer) dispatcher(ctx context.Context, name string, codec Codec, data []byte, stream *serverStream) (rsp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Errorf("rpc.ListenAndServer: %s panic: %v\n%s", name, p, debug.Stack())
+			rsp, err = nil, Errorf(Internal, "%s panic: %v", name, p)
+		}
+	}()
+
 	//获取函数名对应的handle
-	rh, ok := r.router[cReq.Name]
+	rh, ok := r.router[name]
 	if !ok {
-		return nil, fmt.Errorf("rpc.ListenAndServe: can't find handler named %s", cReq.Name)
+		return nil, Errorf(NotFound, "can't find handler named %s", name)
+	}
+	if (rh.stream != nil) != (stream != nil) {
+		if stream == nil {
+			return nil, Errorf(BadRequest, "%s is a streaming method, use Stream", name)
+		}
+		return nil, Errorf(BadRequest, "%s is not a streaming method", name)
 	}
 
 	//解析参数类型， 根据此类型去接收data内容 保存到args.  args即使handle的实际参数.
-	args := reflect.New(rh.argsType).Interface()
-	if err := json.Unmarshal(cReq.Data, args); err != nil {
-		return nil, err
+	args := newArgs(rh.argsType)
+	if err := codec.Unmarshal(data, args); err != nil {
+		return nil, Errorf(BadRequest, "bad args for %s: %v", name, err)
 	}
-	// 由rpcHandler的具柄handler来处理对应的内容.
-	return rh.handler(args), nil
-}
-
-func (r *RPCServer) packResponse(v interface{}) ([]byte, error) {
-	return pack(v)
+	// 依次经过拦截器, 最后由rpcHandler的具柄handler来处理对应的内容.
+	info := &ServerInfo{Method: name, ReplyType: rh.replysType, Stream: stream != nil}
+	h := rh.handler
+	if stream != nil {
+		h = func(ctx context.Context, args interface{}) (interface{}, error) {
+			return nil, rh.stream(ctx, args, stream)
+		}
+	}
+	return r.chain(info, h)(ctx, args)
 }
@@ -0,0 +1,519 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type echoReq struct {
+	Msg   string `json:"msg"`
+	Sleep int    `json:"sleep"` // 处理前等待的毫秒数.
+}
+
+type echoResp struct {
+	Msg string `json:"msg"`
+}
+
+// EchoService 测试用的服务, 等待req.Sleep毫秒后原样返回Msg.
+func EchoService(ctx context.Context, req echoReq) (resp echoResp) {
+	select {
+	case <-time.After(time.Duration(req.Sleep) * time.Millisecond):
+	case <-ctx.Done():
+	}
+	resp.Msg = req.Msg
+	return
+}
+
+// Echo EchoService的句柄.
+func Echo(ctx context.Context, v interface{}) interface{} {
+	return EchoService(ctx, *v.(*echoReq))
+}
+
+// Greeter 测试RegisterService用的服务.
+type Greeter struct{}
+
+// Hello 返回"hello "+req.Msg.
+func (*Greeter) Hello(ctx context.Context, req echoReq) (echoResp, error) {
+	return echoResp{Msg: "hello " + req.Msg}, nil
+}
+
+// Fail 总是返回错误, 用于测试错误的状态码.
+func (*Greeter) Fail(ctx context.Context, req echoReq) (echoResp, error) {
+	if req.Msg == "" {
+		return echoResp{}, errors.New("internal failure")
+	}
+	return echoResp{}, Errorf(NotFound, "no such user %s", req.Msg)
+}
+
+// Panic 类型断言失败导致panic, 用于测试panic只影响当前请求.
+func (*Greeter) Panic(ctx context.Context, req echoReq) (echoResp, error) {
+	var v interface{} = req.Msg
+	return echoResp{Msg: "hello " + v.(fmt.Stringer).String()}, nil
+}
+
+// Ignored 不符合服务方法的形式, 不会被注册.
+func (*Greeter) Ignored(msg string) string {
+	return msg
+}
+
+// startServer 在随机端口启动注册了Echo和Greeter服务的rpc服务端, 返回连接到该服务端的客户端.
+func startServer(t *testing.T, opts ...Option) *RPCClient {
+	server := newTestServer(t)
+	return serve(t, server, opts...)
+}
+
+// newTestServer 返回注册了Echo和Greeter服务的rpc服务端.
+func newTestServer(t *testing.T) *RPCServer {
+	server := Server()
+	if err := server.Register("Echo", Echo, EchoService); err != nil {
+		t.Fatalf("Register failed. err:%v", err)
+	}
+	if err := server.RegisterService(&Greeter{}); err != nil {
+		t.Fatalf("RegisterService failed. err:%v", err)
+	}
+	return server
+}
+
+// serve 在随机端口启动server, 返回连接到该服务端的客户端.
+func serve(t *testing.T, server *RPCServer, opts ...Option) *RPCClient {
+	client, err := Client(2, listen(t, server), opts...)
+	if err != nil {
+		t.Fatalf("Client failed. err:%v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// listen 在随机端口启动server, 返回监听的地址. 测试结束时停止监听.
+func listen(t *testing.T, server *RPCServer) string {
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed. err:%v", err)
+	}
+	go server.accept(listener)
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+// TestCall 测试rpc调用的应答以及各种失败状态码.
+func TestCall(t *testing.T) {
+	client := startServer(t)
+
+	var tests = []struct {
+		name    string
+		req     interface{}
+		timeout time.Duration
+		code    Code
+	}{
+		{"Echo", echoReq{Msg: "hello"}, time.Second, OK},
+		{"Greeter.Hello", echoReq{}, time.Second, OK},
+		{"Greeter.Fail", echoReq{Msg: "bot1"}, time.Second, NotFound},
+		{"Greeter.Fail", echoReq{}, time.Second, Internal},
+		{"Greeter.Panic", echoReq{}, time.Second, Internal},
+		{"Greeter.Ignored", echoReq{}, time.Second, NotFound},
+		{"NoExist", echoReq{Msg: "hello"}, time.Second, NotFound},
+		{"Echo", "not a struct", time.Second, BadRequest},
+		{"Echo", echoReq{Msg: "hello", Sleep: 1000}, 50 * time.Millisecond, DeadlineExceeded},
+	}
+	for _, test := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+		var resp echoResp
+		err := client.CallContext(ctx, test.name, test.req, &resp)
+		cancel()
+
+		var e *Error
+		if test.code == OK {
+			if err != nil || !strings.HasPrefix(resp.Msg, "hello") {
+				t.Errorf("Call didn't pass. name:%s, resp:%v, err:%v", test.name, resp, err)
+			}
+		} else if !errors.As(err, &e) || e.Code != test.code {
+			t.Errorf("Call didn't pass. name:%s, code:%s, err:%v", test.name, test.code, err)
+		}
+	}
+}
+
+// TestBadFrame 测试收到格式错误的数据时服务端关闭该连接.
+func TestBadFrame(t *testing.T) {
+	server := newTestServer(t)
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed. err:%v", err)
+	}
+	defer listener.Close()
+	go server.accept(listener)
+
+	badVersion, _ := packFrame(frame{typ: frameRequest, name: "Echo"}, DefaultMaxMessageSize)
+	badVersion[1] = FrameVersion + 1
+	var tests = []struct {
+		name string
+		data []byte
+	}{
+		{"bad version", badVersion},
+		{"bad legacy header", []byte("12ab{}")},
+		{"unknown header", []byte("hello")},
+	}
+	for _, test := range tests {
+		conn, err := net.Dial("tcp4", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("dial failed. err:%v", err)
+		}
+		conn.Write(test.data)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("bad frame didn't pass. name:%s, err:%v", test.name, err)
+		}
+		conn.Close()
+	}
+}
+
+// TestRollout 测试升级到二进制帧时需要先升级服务端: 新版服务端兼容旧版ASCII帧的客户端,
+// 而新版客户端连接旧版服务端时调用以Unavailable失败, 不会一直等待.
+func TestRollout(t *testing.T) {
+	//旧版客户端 -> 新版服务端.
+	addr := listen(t, newTestServer(t))
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatalf("dial failed. err:%v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	data, _ := json.Marshal(echoReq{Msg: "hello"})
+	reqBytes, err := pack(request{Name: "Echo", Data: data})
+	if err != nil {
+		t.Fatalf("pack failed. err:%v", err)
+	}
+	var resp echoResp
+	if _, err = conn.Write(reqBytes); err == nil {
+		var body []byte
+		if body, err = unpack(conn); err == nil {
+			err = json.Unmarshal(body, &resp)
+		}
+	}
+	if err != nil || resp.Msg != "hello" {
+		t.Errorf("legacy client didn't pass. resp:%v, err:%v", resp, err)
+	}
+
+	//新版客户端 -> 旧版服务端. 旧版服务端无法解析二进制帧, 总是以ASCII帧应答null.
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed. err:%v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				null, _ := pack(nil)
+				buff := make([]byte, 1024)
+				for {
+					if _, err := conn.Read(buff); err != nil {
+						return
+					}
+					conn.Write(null)
+				}
+			}()
+		}
+	}()
+	client, err := Client(1, listener.Addr().String(), RedialBackoff(time.Second, time.Second))
+	if err != nil {
+		t.Fatalf("Client failed. err:%v", err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = client.CallContext(ctx, "Echo", echoReq{Msg: "hello"}, &resp)
+	if CodeOf(err) != Unavailable || !errors.Is(err, ErrBadMagic) {
+		t.Errorf("new client with legacy server didn't pass. err:%v", err)
+	}
+}
+
+// TestRedial 测试服务端断开连接后客户端自动重连.
+func TestRedial(t *testing.T) {
+	server := Server()
+	if err := server.Register("Echo", Echo, EchoService); err != nil {
+		t.Fatalf("Register failed. err:%v", err)
+	}
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed. err:%v", err)
+	}
+	defer listener.Close()
+	//记录服务端的连接, 用于模拟服务端重启时连接断开.
+	conns := make(chan *net.TCPConn, 10)
+	go func() {
+		for {
+			conn, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go server.handle(conn)
+		}
+	}()
+
+	client, err := Client(1, listener.Addr().String(), RedialBackoff(10*time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Client failed. err:%v", err)
+	}
+	defer client.Close()
+
+	(<-conns).Close()
+	deadline := time.Now().Add(time.Second)
+	for client.Health().Live != 1 || len(conns) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("redial didn't pass. health:%+v", client.Health())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var resp echoResp
+	if err := client.Call("Echo", echoReq{Msg: "hello"}, &resp); err != nil || resp.Msg != "hello" {
+		t.Errorf("Call after redial didn't pass. resp:%v, err:%v", resp, err)
+	}
+}
+
+// TestInterceptor 测试拦截器的执行顺序以及不调用next直接返回.
+func TestInterceptor(t *testing.T) {
+	var trace []string
+	var mu sync.Mutex
+	record := func(name string) ServerInterceptor {
+		return func(ctx context.Context, info *ServerInfo, req interface{}, next Handler) (interface{}, error) {
+			mu.Lock()
+			trace = append(trace, name+" "+info.Method)
+			mu.Unlock()
+			return next(ctx, req)
+		}
+	}
+	//拒绝Msg为"deny"的请求, 不调用服务函数.
+	deny := func(ctx context.Context, info *ServerInfo, req interface{}, next Handler) (interface{}, error) {
+		if req.(*echoReq).Msg == "deny" {
+			return reflect.New(info.ReplyType).Elem().Interface(), Errorf(BadRequest, "denied")
+		}
+		return next(ctx, req)
+	}
+
+	server := newTestServer(t)
+	server.Use(record("first"), record("second"), deny)
+	client := serve(t, server)
+
+	var tests = []struct {
+		msg   string
+		code  Code
+		trace string
+	}{
+		{"bot1", OK, "first Greeter.Hello,second Greeter.Hello"},
+		{"deny", BadRequest, "first Greeter.Hello,second Greeter.Hello"},
+	}
+	for _, test := range tests {
+		trace = nil
+		var resp echoResp
+		err := client.Call("Greeter.Hello", echoReq{Msg: test.msg}, &resp)
+		if CodeOf(err) != test.code || strings.Join(trace, ",") != test.trace {
+			t.Errorf("interceptor didn't pass. msg:%s, trace:%v, err:%v", test.msg, trace, err)
+		}
+		if test.code == OK && resp.Msg != "hello "+test.msg {
+			t.Errorf("interceptor didn't pass. msg:%s, resp:%v", test.msg, resp)
+		}
+	}
+}
+
+// TestShutdown 测试Shutdown等待正在处理的请求完成, 以及超时后直接关闭连接.
+func TestShutdown(t *testing.T) {
+	var tests = []struct {
+		sleep   int           // 请求处理的毫秒数.
+		timeout time.Duration // Shutdown的超时时间.
+		err     error         // Shutdown返回的错误.
+		code    Code          // 请求的状态码.
+	}{
+		{200, time.Second, nil, OK},
+		{500, 50 * time.Millisecond, context.DeadlineExceeded, Unavailable},
+	}
+	for _, test := range tests {
+		server := newTestServer(t)
+		listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("listen failed. err:%v", err)
+		}
+		accepted := make(chan error, 1)
+		go func() { accepted <- server.accept(listener) }()
+		client, err := Client(1, listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Client failed. err:%v", err)
+		}
+
+		called := make(chan error, 1)
+		go func() {
+			var resp echoResp
+			called <- client.Call("Echo", echoReq{Msg: "hello", Sleep: test.sleep}, &resp)
+		}()
+		for atomic.LoadInt64(&server.active) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+		err = server.Shutdown(ctx)
+		cancel()
+		if err != test.err {
+			t.Errorf("Shutdown didn't pass. sleep:%d, err:%v", test.sleep, err)
+		}
+		if err := <-called; CodeOf(err) != test.code {
+			t.Errorf("Call during shutdown didn't pass. sleep:%d, err:%v", test.sleep, err)
+		}
+		if err := <-accepted; err != ErrServerClosed {
+			t.Errorf("accept after shutdown didn't pass. err:%v", err)
+		}
+		var resp echoResp
+		if err := client.Call("Echo", echoReq{Msg: "hello"}, &resp); CodeOf(err) != Unavailable {
+			t.Errorf("Call after shutdown didn't pass. err:%v", err)
+		}
+		client.Close()
+	}
+}
+
+// TestShutdownDrain 测试Shutdown期间读到的请求以Unavailable应答, 已经开始的请求正常应答,
+// 并且服务端在客户端确认goaway帧之后才关闭连接.
+func TestShutdownDrain(t *testing.T) {
+	server := newTestServer(t)
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed. err:%v", err)
+	}
+	go server.accept(listener)
+	conn, err := net.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed. err:%v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	send := func(f frame) {
+		b, err := packFrame(f, DefaultMaxMessageSize)
+		if err != nil {
+			t.Fatalf("packFrame failed. err:%v", err)
+		}
+		if _, err := conn.Write(b); err != nil {
+			t.Fatalf("write frame didn't pass. type:%d, err:%v", f.typ, err)
+		}
+	}
+	send(frame{typ: frameRequest, codec: CodecJSON, id: 1, name: "Echo", body: []byte(`{"msg":"slow","sleep":200}`)})
+	for atomic.LoadInt64(&server.active) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	if f, err := readFrame(conn, DefaultMaxMessageSize); err != nil || f.typ != frameGoAway {
+		t.Fatalf("goaway didn't pass. frame:%v, err:%v", f, err)
+	}
+
+	//收到goaway之前已经发出的请求.
+	send(frame{typ: frameRequest, codec: CodecJSON, id: 2, name: "Echo", body: []byte(`{"msg":"late"}`)})
+	send(frame{typ: frameStream, codec: CodecJSON, id: 3, name: "Greeter.Count", body: []byte(`{"msg":"late","n":1}`)})
+	var tests = []struct {
+		id     uint64
+		typ    byte
+		status Code
+	}{
+		{1, frameResponse, OK},
+		{2, frameResponse, Unavailable},
+		{3, frameStreamEnd, Unavailable},
+	}
+	got := make(map[uint64]frame)
+	for len(got) < len(tests) {
+		f, err := readFrame(conn, DefaultMaxMessageSize)
+		if err != nil {
+			t.Fatalf("read response during shutdown didn't pass. got:%d, err:%v", len(got), err)
+		}
+		got[f.id] = f
+	}
+	for _, test := range tests {
+		if f := got[test.id]; f.typ != test.typ || f.status != test.status {
+			t.Errorf("response during shutdown didn't pass. id:%d, type:%d, status:%s", test.id, f.typ, f.status)
+		}
+	}
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned before goaway ack. err:%v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	send(frame{typ: frameGoAway})
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown didn't pass. err:%v", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("close after ack didn't pass. err:%v", err)
+	}
+}
+
+// TestInMemoryClient 测试通过net.Pipe连接到进程内服务端的客户端, 以及用RegisterServiceName注册的服务.
+func TestInMemoryClient(t *testing.T) {
+	server := newTestServer(t)
+	if err := server.RegisterServiceName("Fake", &Greeter{}); err != nil {
+		t.Fatalf("RegisterServiceName failed. err:%v", err)
+	}
+
+	var tests = []struct {
+		codec byte
+		name  string
+		code  Code
+	}{
+		{CodecJSON, "Echo", OK},
+		{CodecMsgpack, "Greeter.Hello", OK},
+		{CodecMsgpack, "Fake.Hello", OK},
+		{CodecJSON, "Fake.Fail", NotFound},
+		{CodecMsgpack, "Fake.Ignored", NotFound},
+	}
+	for _, test := range tests {
+		client, err := InMemoryClient(server, UseCodec(test.codec))
+		if err != nil {
+			t.Fatalf("InMemoryClient failed. err:%v", err)
+		}
+		var resp echoResp
+		err = client.Call(test.name, echoReq{Msg: "bot1"}, &resp)
+		if test.code == OK && (err != nil || !strings.HasSuffix(resp.Msg, "bot1")) || CodeOf(err) != test.code {
+			t.Errorf("InMemoryClient didn't pass. codec:%d, name:%s, resp:%v, err:%v", test.codec, test.name, resp, err)
+		}
+		client.Close()
+	}
+}
+
+// TestDescribe 测试内置的DescribeMethod返回注册的方法及其类型.
+func TestDescribe(t *testing.T) {
+	client := startServer(t, UseCodec(CodecMsgpack))
+	methods, err := client.Describe(context.Background())
+	if err != nil {
+		t.Fatalf("Describe failed. err:%v", err)
+	}
+	got := make(map[string]MethodDesc, len(methods))
+	for _, m := range methods {
+		got[m.Name] = m
+	}
+
+	var tests = []MethodDesc{
+		{Name: "Echo", Args: "rpc.echoReq", Reply: "rpc.echoResp"},
+		{Name: "Greeter.Hello", Args: "rpc.echoReq", Reply: "rpc.echoResp"},
+		{Name: "Greeter.Count", Args: "rpc.countReq", Reply: "rpc.echoResp", Stream: true},
+	}
+	for _, test := range tests {
+		if got[test.Name] != test {
+			t.Errorf("Describe didn't pass. want:%v, got:%v", test, got[test.Name])
+		}
+	}
+	if _, ok := got[DescribeMethod]; ok || len(got) != len(methods) {
+		t.Errorf("Describe didn't pass. methods:%v", methods)
+	}
+}
@@ -0,0 +1,373 @@
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+	"usermana/log"
+)
+
+// streamHandler 流式方法的处理函数, req为参数结构体的指针, 消息通过stream发送.
+type streamHandler func(ctx context.Context, req interface{}, stream *serverStream) error
+
+// checkStreamType 检查fn是否是形如func(context.Context, Req, func(Msg) error) error的流式方法.
+func checkStreamType(fn reflect.Type) error {
+	if fn.Kind() != reflect.Func || fn.NumIn() != 3 || fn.NumOut() != 1 {
+		return errors.New("rpc.Register: stream handler must be func(context.Context, Req, func(Msg) error) error")
+	}
+	if fn.In(0) != contextType || !isStructOrPtr(fn.In(1)) || fn.Out(0) != errorType {
+		return errors.New("rpc.Register: stream handler must be func(context.Context, Req, func(Msg) error) error")
+	}
+	send := fn.In(2)
+	if send.Kind() != reflect.Func || send.NumIn() != 1 || send.NumOut() != 1 ||
+		!isStructOrPtr(send.In(0)) || send.Out(0) != errorType {
+		return errors.New("rpc.Register: stream handler third parameter must be func(Msg) error")
+	}
+	return nil
+}
+
+// reflectStreamHandler 将形如func(context.Context, Req, func(Msg) error) error的函数包装为streamHandler.
+func reflectStreamHandler(fn reflect.Value) streamHandler {
+	sendType := fn.Type().In(2)
+	return func(ctx context.Context, args interface{}, stream *serverStream) error {
+		send := reflect.MakeFunc(sendType, func(in []reflect.Value) []reflect.Value {
+			err := stream.send(in[0].Interface())
+			return []reflect.Value{reflect.ValueOf(&err).Elem()}
+		})
+		out := fn.Call([]reflect.Value{reflect.ValueOf(ctx), argValue(fn.Type().In(1), args), send})
+		err, _ := out[0].Interface().(error)
+		return err
+	}
+}
+
+// serverStream 服务端一个正在进行的流式调用.
+type serverStream struct {
+	sc      *serverConn
+	id      uint64
+	codecID byte
+	codec   Codec
+	maxSize int
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	credits int           // 客户端允许发送的消息数.
+	more    chan struct{} // 收到window帧时通知send.
+}
+
+// openStream 登记请求帧req对应的流式调用.
+func (sc *serverConn) openStream(req frame, maxSize int) *serverStream {
+	ctx, cancel := requestContext(req)
+	stream := &serverStream{
+		sc:      sc,
+		id:      req.id,
+		codecID: req.codec,
+		maxSize: maxSize,
+		ctx:     ctx,
+		cancel:  cancel,
+		more:    make(chan struct{}, 1),
+	}
+	sc.smu.Lock()
+	defer sc.smu.Unlock()
+	if sc.streams == nil {
+		sc.streams = make(map[uint64]*serverStream)
+	}
+	sc.streams[req.id] = stream
+	return stream
+}
+
+// getStream 返回id对应的流式调用, 已经结束时返回nil.
+func (sc *serverConn) getStream(id uint64) *serverStream {
+	sc.smu.Lock()
+	defer sc.smu.Unlock()
+	return sc.streams[id]
+}
+
+// closeStream 取消并删除流式调用.
+func (sc *serverConn) closeStream(stream *serverStream) {
+	stream.cancel()
+	sc.smu.Lock()
+	defer sc.smu.Unlock()
+	delete(sc.streams, stream.id)
+}
+
+// cancelStreams 取消连接上的所有流式调用.
+func (sc *serverConn) cancelStreams() {
+	sc.smu.Lock()
+	defer sc.smu.Unlock()
+	for _, stream := range sc.streams {
+		stream.cancel()
+	}
+}
+
+// grant 客户端允许再发送n个消息.
+func (s *serverStream) grant(n int) {
+	s.mu.Lock()
+	s.credits += n
+	s.mu.Unlock()
+	select {
+	case s.more <- struct{}{}:
+	default:
+	}
+}
+
+// send 编码v并作为一个消息帧发送. 没有额度时等待客户端的window帧, 流被取消或超时时返回错误.
+func (s *serverStream) send(v interface{}) error {
+	for {
+		s.mu.Lock()
+		if s.credits > 0 {
+			s.credits--
+			s.mu.Unlock()
+			break
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.more:
+		case <-s.ctx.Done():
+			return contextError(s.ctx.Err())
+		}
+	}
+
+	body, err := s.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b, err := packFrame(frame{typ: frameStreamMsg, codec: s.codecID, id: s.id, body: body}, s.maxSize)
+	if err != nil {
+		return err
+	}
+	return s.sc.write(b)
+}
+
+// serveStream 调用流式方法, 方法返回后发送结束帧, 失败时结束帧带上状态码和错误信息.
+func (r *RPCServer) serveStream(stream *serverStream, req frame) {
+	defer atomic.AddInt64(&r.active, -1)
+	defer stream.sc.closeStream(stream)
+
+	end := frame{typ: frameStreamEnd, codec: req.codec, id: req.id}
+	codec, err := getCodec(req.codec)
+	if err != nil {
+		err = Errorf(BadRequest, "%v", err)
+	} else {
+		stream.codec = codec
+		var rsp interface{}
+		if rsp, err = r.dispatcher(stream.ctx, req.name, codec, req.body, stream); err == nil && rsp != nil {
+			//拦截器没有调用流式方法而直接返回了应答(如鉴权失败), 作为唯一的消息发送.
+			err = stream.send(rsp)
+		}
+	}
+	if err != nil {
+		e := toError(err)
+		if !errors.Is(err, context.Canceled) {
+			log.Errorf("rpc.ListenAndServer: stream %s failed. code:%s, err:%q", req.name, e.Code, e.Message)
+		}
+		end.status, end.body = e.Code, []byte(e.Message)
+	}
+	endBytes, err := packFrame(end, r.opts.maxMessageSize)
+	if err != nil {
+		end.status, end.body = Internal, []byte(err.Error())
+		endBytes, _ = packFrame(end, r.opts.maxMessageSize)
+	}
+	if err := stream.sc.write(endBytes); err != nil && stream.ctx.Err() == nil {
+		log.Errorf("rpc.ListenAndServer: connection write stream end failed. err:%q", err)
+	}
+}
+
+// Stream 服务端流式调用的客户端, 通过Next依次读取服务端发送的消息. 使用完后需要调用Close.
+//
+//	stream, err := client.Stream(ctx, "User.Export", req)
+//	if err != nil { ... }
+//	defer stream.Close()
+//	var user protocol.RespGetProfile
+//	for stream.Next(&user) {
+//		...
+//	}
+//	if err := stream.Err(); err != nil { ... }
+//
+// 流式调用不经过客户端拦截器. 一个Stream只能在一个协程中使用.
+type Stream struct {
+	ctx      context.Context
+	conn     *clientConn
+	id       uint64
+	ch       chan frame
+	window   int
+	received int    // 上次发送window帧之后读取的消息数.
+	release  func() // 流结束时调用.
+	err      error
+	done     bool
+}
+
+// Stream 调用流式方法name(req), 返回读取消息的Stream. ctx结束时流也结束.
+func (r *RPCClient) Stream(ctx context.Context, name string, req interface{}) (*Stream, error) {
+	f, err := r.packRequest(name, req)
+	if err != nil {
+		return nil, err
+	}
+	f.typ = frameStream
+	if deadline, ok := ctx.Deadline(); ok {
+		f.timeout = time.Until(deadline)
+		if f.timeout <= 0 {
+			return nil, contextError(context.DeadlineExceeded)
+		}
+	}
+
+	b := r.getBackend(ctx)
+	s := &Stream{ctx: ctx, conn: b.getConn(), id: f.id, window: r.opts.streamWindow}
+	//消息帧最多window个, 再加上结束帧.
+	s.ch = make(chan frame, s.window+1)
+	if err := s.conn.openStream(ctx, f, s.ch, s.window); err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&b.outstanding, 1)
+	s.release = func() { atomic.AddInt64(&b.outstanding, -1) }
+	return s, nil
+}
+
+// Next 读取下一个消息并解码到v中, 成功时返回true. 流结束或出错时返回false, 错误见Err.
+func (s *Stream) Next(v interface{}) bool {
+	if s.done {
+		return false
+	}
+	select {
+	case f, ok := <-s.ch:
+		switch {
+		case !ok:
+			s.conn.mu.Lock()
+			s.err = s.conn.unavailableLocked()
+			s.conn.mu.Unlock()
+		case f.typ == frameStreamEnd:
+			if f.status != OK {
+				s.err = &Error{Code: f.status, Message: string(f.body)}
+			}
+		default:
+			//读取了一半窗口的消息后, 允许服务端继续发送.
+			if s.received++; s.received >= (s.window+1)/2 {
+				s.conn.grant(s.id, s.received)
+				s.received = 0
+			}
+			codec, err := getCodec(f.codec)
+			if err == nil {
+				err = codec.Unmarshal(f.body, v)
+			}
+			if err == nil {
+				return true
+			}
+			s.err = err
+			s.conn.cancelStream(s.id)
+		}
+	case <-s.ctx.Done():
+		s.err = contextError(s.ctx.Err())
+		s.conn.cancelStream(s.id)
+	}
+	s.finish()
+	return false
+}
+
+// Err 返回使流提前结束的错误, 流正常结束时返回nil.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Close 不再读取剩余的消息, 通知服务端取消流式调用. 流已经结束时什么都不做.
+func (s *Stream) Close() error {
+	if !s.done {
+		s.conn.cancelStream(s.id)
+		s.finish()
+	}
+	return nil
+}
+
+// finish 标记流已经结束.
+func (s *Stream) finish() {
+	s.done = true
+	s.release()
+}
+
+// openStream 发送流式请求帧f和初始的window帧, 服务端发送的消息帧和结束帧交给ch.
+func (c *clientConn) openStream(ctx context.Context, f frame, ch chan frame, window int) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+	reqBytes, err := packFrame(f, c.opts.maxMessageSize)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.state != stateLive {
+		err = c.unavailableLocked()
+		c.mu.Unlock()
+		return err
+	}
+	conn := c.conn
+	c.pending[f.id] = ch
+	c.writing++
+	c.mu.Unlock()
+
+	err = c.write(conn, append(reqBytes, windowFrame(f.id, window, c.opts.maxMessageSize)...))
+	c.endWrite()
+	if err != nil {
+		c.fail(conn, err)
+	}
+	return nil
+}
+
+// grant 允许服务端在流id上再发送n个消息.
+func (c *clientConn) grant(id uint64, n int) {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	//连接已经断开时流也已经结束, 忽略写入错误.
+	c.write(conn, windowFrame(id, n, c.opts.maxMessageSize))
+}
+
+// cancelStream 不再接收流id的消息, 并通知服务端取消.
+func (c *clientConn) cancelStream(id uint64) {
+	c.mu.Lock()
+	conn := c.conn
+	_, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if ok {
+		b, _ := packFrame(frame{typ: frameCancel, id: id}, c.opts.maxMessageSize)
+		c.write(conn, b)
+	}
+}
+
+// errWindowExceeded 服务端发送的消息超过了流的窗口.
+var errWindowExceeded = errors.New("rpc: server exceeded the stream window")
+
+// deliverStream 把流式调用的消息帧或结束帧交给等待的Stream, 收到结束帧后流结束.
+func (c *clientConn) deliverStream(f frame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.pending[f.id]
+	if !ok {
+		return
+	}
+	//ch的容量是窗口大小加上结束帧, 消息超过窗口说明服务端没有遵守流量控制: 用预留给结束帧的位置
+	//放入ResourceExhausted的结束帧, 已经收到的消息仍然可以读取, 并通知服务端取消.
+	if f.typ == frameStreamMsg && len(ch) >= cap(ch)-1 {
+		log.Errorf("rpc.Stream: server ignored flow control, stream %d aborted.", f.id)
+		f = frame{typ: frameStreamEnd, id: f.id, status: ResourceExhausted, body: []byte(errWindowExceeded.Error())}
+		cancel, _ := packFrame(frame{typ: frameCancel, id: f.id}, c.opts.maxMessageSize)
+		go c.write(c.conn, cancel)
+	}
+	if f.typ == frameStreamEnd {
+		delete(c.pending, f.id)
+	}
+	//在持有锁时发送, 避免fail同时关闭ch. 消息不超过窗口时ch总有空位, 不会阻塞.
+	ch <- f
+}
+
+// windowFrame 返回允许流id再发送n个消息的window帧.
+func windowFrame(id uint64, n int, maxSize int) []byte {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, uint32(n))
+	b, _ := packFrame(frame{typ: frameWindow, id: id, body: body}, maxSize)
+	return b
+}
@@ -0,0 +1,200 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countReq Greeter.Count的参数.
+type countReq struct {
+	Msg string `json:"msg"`
+	N   int    `json:"n"`
+}
+
+// countSent Greeter.Count已经发送的消息数, countDone Count返回时写入其错误.
+var (
+	countSent int64
+	countDone = make(chan error, 1)
+)
+
+// Count 流式方法, 依次发送N个消息, Msg为fail时发送完后返回NotFound.
+func (*Greeter) Count(ctx context.Context, req countReq, send func(echoResp) error) (err error) {
+	defer func() {
+		select {
+		case countDone <- err:
+		default:
+		}
+	}()
+	for i := 0; i < req.N; i++ {
+		if err := send(echoResp{Msg: fmt.Sprintf("%s %d", req.Msg, i)}); err != nil {
+			return err
+		}
+		atomic.AddInt64(&countSent, 1)
+	}
+	if req.Msg == "fail" {
+		return Errorf(NotFound, "no such user %s", req.Msg)
+	}
+	return nil
+}
+
+// TestStream 测试流式调用的消息、结束帧以及各种失败状态码.
+func TestStream(t *testing.T) {
+	server := newTestServer(t)
+	//拦截器不调用流式方法直接返回应答时, 应答作为唯一的消息发送.
+	server.Use(func(ctx context.Context, info *ServerInfo, req interface{}, next Handler) (interface{}, error) {
+		if r, ok := req.(*countReq); ok && info.Stream && r.Msg == "deny" {
+			return echoResp{Msg: "deny 0"}, nil
+		}
+		return next(ctx, req)
+	})
+	client := serve(t, server, UseCodec(CodecMsgpack), StreamWindow(4))
+
+	var tests = []struct {
+		name  string
+		req   countReq
+		count int
+		code  Code
+	}{
+		{"Greeter.Count", countReq{Msg: "bot1", N: 100}, 100, OK},
+		{"Greeter.Count", countReq{Msg: "bot1"}, 0, OK},
+		{"Greeter.Count", countReq{Msg: "fail", N: 3}, 3, NotFound},
+		{"Greeter.Count", countReq{Msg: "deny", N: 3}, 1, OK},
+		{"Greeter.Hello", countReq{}, 0, BadRequest},
+		{"NoExist", countReq{}, 0, NotFound},
+	}
+	for _, test := range tests {
+		stream, err := client.Stream(context.Background(), test.name, test.req)
+		if err != nil {
+			t.Fatalf("Stream failed. err:%v", err)
+		}
+		count := 0
+		var resp echoResp
+		for stream.Next(&resp) {
+			if resp.Msg != fmt.Sprintf("%s %d", test.req.Msg, count) {
+				t.Errorf("Stream didn't pass. name:%s, count:%d, resp:%v", test.name, count, resp)
+			}
+			count++
+		}
+		stream.Close()
+		if count != test.count || CodeOf(stream.Err()) != test.code {
+			t.Errorf("Stream didn't pass. name:%s, count:%d, err:%v", test.name, count, stream.Err())
+		}
+	}
+
+	//流式方法不能通过Call调用.
+	if err := client.Call("Greeter.Count", countReq{N: 1}, &echoResp{}); CodeOf(err) != BadRequest {
+		t.Errorf("Call stream method didn't pass. err:%v", err)
+	}
+}
+
+// TestStreamFlowControl 测试客户端读取慢时服务端不会多发送超过窗口的消息, 以及Close后服务端取消流式方法.
+func TestStreamFlowControl(t *testing.T) {
+	const window = 4
+	client := startServer(t, StreamWindow(window))
+	atomic.StoreInt64(&countSent, 0)
+	select {
+	case <-countDone:
+	default:
+	}
+
+	stream, err := client.Stream(context.Background(), "Greeter.Count", countReq{Msg: "bot1", N: 1000})
+	if err != nil {
+		t.Fatalf("Stream failed. err:%v", err)
+	}
+	read := 0
+	for ; read < 5 && stream.Next(&echoResp{}); read++ {
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sent := atomic.LoadInt64(&countSent); sent > int64(read+window) {
+		t.Errorf("Stream flow control didn't pass. read:%d, sent:%d", read, sent)
+	}
+
+	stream.Close()
+	select {
+	case err := <-countDone:
+		if CodeOf(err) == OK {
+			t.Errorf("Stream close didn't cancel server. err:%v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Stream close didn't cancel server.")
+	}
+
+	//Stream的ctx结束时流也结束.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if stream, err = client.Stream(ctx, "Greeter.Count", countReq{Msg: "bot1", N: 1000}); err != nil {
+		t.Fatalf("Stream failed. err:%v", err)
+	}
+	for stream.Next(&echoResp{}) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if CodeOf(stream.Err()) != DeadlineExceeded {
+		t.Errorf("Stream timeout didn't pass. err:%v", stream.Err())
+	}
+}
+
+// TestStreamWindowExceeded 测试服务端发送的消息超过窗口时, 客户端读完已经收到的消息后以ResourceExhausted结束流, 并通知服务端取消.
+func TestStreamWindowExceeded(t *testing.T) {
+	const window = 2
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed. err:%v", err)
+	}
+	defer listener.Close()
+	//不遵守流量控制的服务端: 收到流式请求后直接发送window+2个消息, 然后等待客户端的cancel帧.
+	canceled := make(chan bool, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := readFrame(conn, DefaultMaxMessageSize)
+		if err != nil {
+			canceled <- false
+			return
+		}
+		for i := 0; i < window+2; i++ {
+			b, _ := packFrame(frame{typ: frameStreamMsg, codec: CodecJSON, id: req.id, body: []byte(`{"msg":"bot1"}`)}, DefaultMaxMessageSize)
+			conn.Write(b)
+		}
+		for {
+			f, err := readFrame(conn, DefaultMaxMessageSize)
+			if err != nil || f.typ == frameCancel {
+				canceled <- err == nil && f.id == req.id
+				return
+			}
+		}
+	}()
+
+	client, err := Client(1, listener.Addr().String(), StreamWindow(window))
+	if err != nil {
+		t.Fatalf("Client failed. err:%v", err)
+	}
+	defer client.Close()
+	stream, err := client.Stream(context.Background(), "Greeter.Count", countReq{Msg: "bot1", N: 10})
+	if err != nil {
+		t.Fatalf("Stream failed. err:%v", err)
+	}
+	defer stream.Close()
+	select {
+	case ok := <-canceled:
+		if !ok {
+			t.Errorf("Stream window exceeded didn't cancel server.")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Stream window exceeded didn't cancel server.")
+	}
+
+	read := 0
+	for stream.Next(&echoResp{}) {
+		read++
+	}
+	if read != window || CodeOf(stream.Err()) != ResourceExhausted {
+		t.Errorf("Stream window exceeded didn't pass. read:%d, err:%v", read, stream.Err())
+	}
+}
@@ -0,0 +1,130 @@
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"usermana/log"
+)
+
+// CertReloader 从文件加载证书、私钥和CA证书, 用于rpc的双向TLS认证.
+// 调用Reload重新读取文件后, 新建立的连接使用新的证书, 已经建立的连接不受影响.
+//
+//	certs, err := rpc.NewCertReloader("server.pem", "server.key", "ca.pem")
+//	server := rpc.Server(rpc.TLS(certs.ServerConfig()))
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// NewCertReloader 加载certFile、keyFile和caFile(PEM格式, 可以包含多个CA证书).
+func NewCertReloader(certFile, keyFile, caFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 重新读取证书、私钥和CA证书, 读取失败时继续使用原来的证书.
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	ca, err := ioutil.ReadFile(r.caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return errors.New("rpc: no certificate in ca file " + r.caFile)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.pool = &cert, pool
+	return nil
+}
+
+// ReloadOnSignal 收到sig(如SIGHUP)时重新读取证书, 更新证书文件后不需要重启进程.
+func (r *CertReloader) ReloadOnSignal(sig ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig...)
+	go func() {
+		for range ch {
+			if err := r.Reload(); err != nil {
+				log.Errorf("rpc.CertReloader: reload %s failed. err:%q", r.certFile, err)
+				continue
+			}
+			log.Infof("rpc.CertReloader: %s reloaded.", r.certFile)
+		}
+	}()
+}
+
+// get 返回当前的证书和CA.
+func (r *CertReloader) get() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// ServerConfig 返回服务端的TLS配置: 要求客户端提供由CA签发的证书.
+func (r *CertReloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		//每次握手时使用最新的证书和CA.
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.get()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			}, nil
+		},
+	}
+}
+
+// ClientConfig 返回客户端的TLS配置: 提供客户端证书, 并用CA校验服务端证书中的serverName.
+// serverName为空时使用连接地址中的host, 地址是IP时必须指定serverName.
+func (r *CertReloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.get()
+			return cert, nil
+		},
+		//tls.Config的RootCAs不能在握手时更新, 因此跳过默认的校验, 由VerifyConnection使用最新的CA校验.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("rpc: server provided no certificate")
+			}
+			_, pool := r.get()
+			opts := x509.VerifyOptions{Roots: pool, DNSName: cs.ServerName, Intermediates: x509.NewCertPool()}
+			if serverName != "" {
+				opts.DNSName = serverName
+			}
+			if opts.DNSName == "" {
+				//地址是IP时没有SNI, 必须指定serverName.
+				return errors.New("rpc: no server name to verify")
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
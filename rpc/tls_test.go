@@ -0,0 +1,145 @@
+package rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA 测试用的CA, 在进程内生成.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCA 生成一个自签名的CA.
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed. err:%v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed. err:%v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 签发名字为name的证书, 将证书、私钥和CA写入dir, 返回三个文件的路径.
+func (ca *testCA) issue(t *testing.T, dir, name string) (certFile, keyFile, caFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed. err:%v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed. err:%v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed. err:%v", err)
+	}
+
+	certFile, keyFile, caFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key"), filepath.Join(dir, name+".ca")
+	for file, data := range map[string][]byte{
+		certFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyFile:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		caFile:   ca.pem,
+	} {
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			t.Fatalf("WriteFile failed. err:%v", err)
+		}
+	}
+	return certFile, keyFile, caFile
+}
+
+// TestTLS 测试双向TLS认证: 只有持有同一CA签发的证书的客户端才能调用, 以及Reload后使用新的证书.
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpc")
+	if err != nil {
+		t.Fatalf("TempDir failed. err:%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, otherCA := newTestCA(t, "usermana ca"), newTestCA(t, "other ca")
+	serverCerts, err := NewCertReloader(ca.issue(t, dir, "usermana-tcp"))
+	if err != nil {
+		t.Fatalf("NewCertReloader failed. err:%v", err)
+	}
+	server := newTestServer(t)
+	server.opts.tlsConfig = serverCerts.ServerConfig()
+	addr := listen(t, server)
+
+	newCerts := func(ca *testCA, name string) *CertReloader {
+		certs, err := NewCertReloader(ca.issue(t, dir, name))
+		if err != nil {
+			t.Fatalf("NewCertReloader failed. err:%v", err)
+		}
+		return certs
+	}
+	var tests = []struct {
+		name string
+		opts []Option
+		ok   bool
+	}{
+		{"mutual tls", []Option{TLS(newCerts(ca, "usermana-http").ClientConfig("usermana-tcp"))}, true},
+		{"wrong server name", []Option{TLS(newCerts(ca, "usermana-http").ClientConfig("other"))}, false},
+		{"untrusted client", []Option{TLS(newCerts(otherCA, "usermana-http").ClientConfig("usermana-tcp"))}, false},
+		{"plain tcp", nil, false},
+	}
+	call := func(opts []Option) error {
+		client, err := Client(1, addr, append(opts, RedialBackoff(time.Second, time.Second))...)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		var resp echoResp
+		return client.Call("Echo", echoReq{Msg: "hello"}, &resp)
+	}
+	for _, test := range tests {
+		if err := call(test.opts); (err == nil) != test.ok {
+			t.Errorf("tls didn't pass. name:%s, err:%v", test.name, err)
+		}
+	}
+
+	//服务端的证书文件换成otherCA签发的证书并信任otherCA后, 只有otherCA签发的客户端证书可以调用.
+	otherCA.issue(t, dir, "usermana-tcp")
+	if err := serverCerts.Reload(); err != nil {
+		t.Fatalf("Reload failed. err:%v", err)
+	}
+	if err := call([]Option{TLS(newCerts(ca, "usermana-http").ClientConfig("usermana-tcp"))}); err == nil {
+		t.Errorf("tls after reload didn't pass. old ca still works")
+	}
+	if err := call([]Option{TLS(newCerts(otherCA, "usermana-http").ClientConfig("usermana-tcp"))}); err != nil {
+		t.Errorf("tls after reload didn't pass. err:%v", err)
+	}
+}
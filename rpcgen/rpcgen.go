@@ -0,0 +1,162 @@
+// rpcgen 根据protocol包中成对的ReqXxx/RespXxx类型生成带类型的rpc客户端, 方法Xxx调用"服务名.Xxx",
+// 调用者不再需要手写方法名字符串, 方法名或参数类型写错时编译失败.
+//
+// 在protocol包中通过go generate使用:
+//
+//	//go:generate go run ../rpcgen -service User -o user_client.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// method 生成的一个客户端方法.
+type method struct {
+	Name string // 方法名, eg:Login.
+	Req  string // 参数类型, eg:ReqLogin.
+	Resp string // 返回值类型, eg:RespLogin.
+	Doc  string // ReqXxx的注释, eg:登录请求.
+}
+
+// data 模版参数.
+type data struct {
+	Package string
+	Service string
+	Methods []method
+}
+
+var clientTemplate = template.Must(template.New("client").Parse(`// Code generated by rpcgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+	"usermana/rpc"
+)
+
+// {{.Service}}Client {{.Service}}服务带类型的客户端.
+type {{.Service}}Client struct {
+	client *rpc.RPCClient
+}
+
+// New{{.Service}}Client 使用client调用{{.Service}}服务.
+func New{{.Service}}Client(client *rpc.RPCClient) *{{.Service}}Client {
+	return &{{.Service}}Client{client: client}
+}
+{{range .Methods}}
+// {{.Name}} 调用{{$.Service}}.{{.Name}}{{if .Doc}}, {{.Doc}}{{end}}.
+func (c *{{$.Service}}Client) {{.Name}}(ctx context.Context, req {{.Req}}) ({{.Resp}}, error) {
+	var resp {{.Resp}}
+	err := c.client.CallContext(ctx, "{{$.Service}}.{{.Name}}", req, &resp)
+	return resp, err
+}
+{{end}}`))
+
+func main() {
+	var service, output string
+	flag.StringVar(&service, "service", "User", "rpc service name")
+	flag.StringVar(&output, "o", "", "output file in the package directory, default stdout")
+	flag.Parse()
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	src, err := generate(dir, service)
+	if err != nil {
+		log.Fatalf("rpcgen: %v", err)
+	}
+	if output == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, output), src, 0644); err != nil {
+		log.Fatalf("rpcgen: %v", err)
+	}
+}
+
+// generate 解析dir中的Go文件(不包括测试和生成的文件), 为每对ReqXxx/RespXxx生成service服务的客户端方法.
+func generate(dir, service string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expect one package in %s, got %d", dir, len(pkgs))
+	}
+
+	var d data
+	types := make(map[string]*ast.TypeSpec)
+	docs := make(map[string]string)
+	for name, pkg := range pkgs {
+		d.Package = name
+		for _, file := range pkg.Files {
+			if ast.IsGenerated(file) {
+				continue
+			}
+			for _, decl := range file.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					ts := spec.(*ast.TypeSpec)
+					if _, ok := ts.Type.(*ast.StructType); !ok {
+						continue
+					}
+					types[ts.Name.Name] = ts
+					if gen.Doc != nil {
+						docs[ts.Name.Name] = gen.Doc.Text()
+					}
+				}
+			}
+		}
+	}
+
+	for name := range types {
+		if !strings.HasPrefix(name, "Req") {
+			continue
+		}
+		m := method{Name: strings.TrimPrefix(name, "Req"), Req: name}
+		m.Resp = "Resp" + m.Name
+		if _, ok := types[m.Resp]; !ok || m.Name == "" {
+			continue
+		}
+		m.Doc = docComment(name, docs[name])
+		d.Methods = append(d.Methods, m)
+	}
+	if len(d.Methods) == 0 {
+		return nil, fmt.Errorf("no ReqXxx/RespXxx types in %s", dir)
+	}
+	sort.Slice(d.Methods, func(i, j int) bool { return d.Methods[i].Name < d.Methods[j].Name })
+	d.Service = service
+
+	var buff bytes.Buffer
+	if err := clientTemplate.Execute(&buff, d); err != nil {
+		return nil, err
+	}
+	return format.Source(buff.Bytes())
+}
+
+// docComment 从类型name的注释(eg:"ReqLogin 登录请求.")中取出说明(eg:"登录请求").
+func docComment(name, doc string) string {
+	doc = strings.TrimSpace(strings.SplitN(doc, "\n", 2)[0])
+	doc = strings.TrimPrefix(doc, name)
+	return strings.TrimSuffix(strings.TrimSpace(doc), ".")
+}
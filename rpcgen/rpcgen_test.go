@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestGenerate 测试为protocol包生成的客户端, 并检查提交的user_client.go是最新的.
+func TestGenerate(t *testing.T) {
+	src, err := generate("../protocol", "User")
+	if err != nil {
+		t.Fatalf("generate failed. err:%v", err)
+	}
+	var tests = []string{
+		"func NewUserClient(client *rpc.RPCClient) *UserClient",
+		"func (c *UserClient) Login(ctx context.Context, req ReqLogin) (RespLogin, error)",
+		`c.client.CallContext(ctx, "User.UpdateNickName", req, &resp)`,
+		"// SignUp 调用User.SignUp, 注册请求.",
+	}
+	for _, test := range tests {
+		if !strings.Contains(string(src), test) {
+			t.Errorf("generate didn't pass. want:%s", test)
+		}
+	}
+
+	committed, err := ioutil.ReadFile("../protocol/user_client.go")
+	if err != nil {
+		t.Fatalf("ReadFile failed. err:%v", err)
+	}
+	if !bytes.Equal(src, committed) {
+		t.Errorf("protocol/user_client.go is out of date, run go generate ./protocol")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"usermana/log"
+	"usermana/redis"
+)
+
+// newAdminMux 返回管理接口的mux, 每个请求都需要在X-Admin-Secret头中带上secret.
+func newAdminMux(secret string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/admin/revokeSessions", requireSecret(secret, http.HandlerFunc(revokeSessionsHandler)))
+	return mux
+}
+
+// requireSecret 校验请求的X-Admin-Secret头, 与secret不同时返回403, 比较的耗时与密钥是否正确无关.
+func requireSecret(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got := req.Header.Get("X-Admin-Secret")
+		if secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+			log.Errorf("tcp.admin: forbidden. path:%s, remote:%s", req.URL.Path, req.RemoteAddr)
+			http.Error(rw, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// checkAdminConfig 检查管理接口的配置: secret不能为空, addr只能是本机地址.
+func checkAdminConfig(addr, secret string) error {
+	if secret == "" {
+		return errors.New("tcp.admin: admin secret couldn't be NULL")
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("tcp.admin: invalid admin addr %q: %v", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("tcp.admin: admin addr %q is not a loopback address", addr)
+	}
+	return nil
+}
+
+// revokeSessionsHandler 管理接口, 退出用户的所有会话(如账号被盗时), 用户所有的token立即失效.
+// eg: curl -H "X-Admin-Secret: $SECRET" -d username=bot1 http://localhost:6195/admin/revokeSessions
+func revokeSessionsHandler(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userName := req.FormValue("username")
+	if userName == "" {
+		http.Error(rw, "username couldn't be NULL", http.StatusBadRequest)
+		return
+	}
+	n, err := redis.DeleteAllSessions(req.Context(), userName)
+	if err != nil {
+		log.Errorf("tcp.admin: redis.DeleteAllSessions failed. username:%s, err:%q", userName, err)
+		http.Error(rw, "revoke sessions failed", http.StatusInternalServerError)
+		return
+	}
+	log.Infof("tcp.admin: revoke all sessions done. username:%s, revoked:%d, remote:%s", userName, n, req.RemoteAddr)
+	fmt.Fprintf(rw, "revoked %d sessions of %s\n", n, userName)
+}
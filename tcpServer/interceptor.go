@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"time"
+	"usermana/log"
+	"usermana/protocol"
+	"usermana/redis"
+	"usermana/rpc"
+)
+
+// logInterceptor 记录每个rpc请求的方法名、耗时和错误.
+func logInterceptor(ctx context.Context, info *rpc.ServerInfo, req interface{}, next rpc.Handler) (interface{}, error) {
+	start := time.Now()
+	resp, err := next(ctx, req)
+	if err != nil {
+		log.Errorf("tcp.rpc: %s failed. cost:%v, err:%q", info.Method, time.Since(start), err)
+		return resp, err
+	}
+	log.Debugf("tcp.rpc: %s done. cost:%v", info.Method, time.Since(start))
+	return resp, nil
+}
+
+// authInterceptor 对实现了protocol.AuthRequest的请求统一校验token.
+// 校验失败时不再调用服务函数, 直接返回结果码为RetTokenInvalid(token错误)或RetFailed(校验出错)的应答.
+func authInterceptor(ctx context.Context, info *rpc.ServerInfo, req interface{}, next rpc.Handler) (interface{}, error) {
+	auth, ok := req.(protocol.AuthRequest)
+	if !ok {
+		return next(ctx, req)
+	}
+	userName, token := auth.AuthInfo()
+	ok, err := checkToken(ctx, userName, token)
+	if err == nil && ok {
+		return next(ctx, req)
+	}
+
+	replyType := info.ReplyType
+	if replyType.Kind() == reflect.Ptr {
+		replyType = replyType.Elem()
+	}
+	resp := reflect.New(replyType)
+	setter, isSetter := resp.Interface().(protocol.RetSetter)
+	if !isSetter {
+		return nil, rpc.Errorf(rpc.Internal, "%s: reply %s has no ret", info.Method, info.ReplyType)
+	}
+	if err != nil {
+		log.Errorf("tcp.%s: checkToken failed. username:%s, session:%s, err:%q", info.Method, userName, redis.SessionID(token), err)
+		setter.SetRet(protocol.RetFailed)
+	} else {
+		setter.SetRet(protocol.RetTokenInvalid)
+	}
+	if info.ReplyType.Kind() == reflect.Ptr {
+		return resp.Interface(), nil
+	}
+	return resp.Elem().Interface(), nil
+}
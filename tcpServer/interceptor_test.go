@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"usermana/protocol"
+	"usermana/redis"
+	"usermana/rpc"
+)
+
+// authProbe 测试authInterceptor的User服务, 记录服务函数被调用的次数.
+type authProbe struct {
+	called int32
+}
+
+// probeReq 需要鉴权的请求.
+type probeReq struct {
+	UserName string
+	Token    string
+}
+
+// AuthInfo 实现AuthRequest.
+func (r probeReq) AuthInfo() (string, string) { return r.UserName, r.Token }
+
+// probeResp 没有实现RetSetter的返回.
+type probeResp struct {
+	Msg string
+}
+
+// GetProfile 鉴权通过后返回用户名.
+func (p *authProbe) GetProfile(ctx context.Context, req protocol.ReqGetProfile) (protocol.RespGetProfile, error) {
+	atomic.AddInt32(&p.called, 1)
+	return protocol.RespGetProfile{UserName: req.UserName}, nil
+}
+
+// NoRet 返回值没有结果码, 鉴权失败时拦截器无法构造返回.
+func (p *authProbe) NoRet(ctx context.Context, req probeReq) (probeResp, error) {
+	atomic.AddInt32(&p.called, 1)
+	return probeResp{Msg: req.UserName}, nil
+}
+
+// TestAuthInterceptor 通过进程内客户端调用注册了tcp server拦截器的服务, 测试鉴权失败时服务函数不会被调用.
+func TestAuthInterceptor(t *testing.T) {
+	ctx := context.Background()
+	if err := redis.SetToken(ctx, "botAuth", "auth-token", "", redis.Session{}, tokenLifetime); err != nil {
+		t.Fatalf("redis.SetToken failed. err:%q", err)
+	}
+	server := rpc.Server()
+	useInterceptors(server)
+	probe := &authProbe{}
+	if err := server.RegisterServiceName("User", probe); err != nil {
+		t.Fatalf("RegisterServiceName failed. err:%v", err)
+	}
+	client, err := rpc.InMemoryClient(server, rpc.UseCodec(rpc.CodecMsgpack))
+	if err != nil {
+		t.Fatalf("InMemoryClient failed. err:%v", err)
+	}
+	defer client.Close()
+
+	var tests = []struct {
+		method string
+		req    interface{}
+		ret    int      // 返回的结果码, 只用于User.GetProfile.
+		code   rpc.Code // 调用返回的错误码.
+		called bool     // 服务函数是否被调用.
+	}{
+		{"User.GetProfile", protocol.ReqGetProfile{UserName: "botAuth", Token: "auth-token"}, 0, rpc.OK, true},
+		{"User.GetProfile", protocol.ReqGetProfile{UserName: "botAuth", Token: "bad-token"}, protocol.RetTokenInvalid, rpc.OK, false},
+		{"User.GetProfile", protocol.ReqGetProfile{UserName: "botAuth"}, protocol.RetTokenInvalid, rpc.OK, false},
+		{"User.GetProfile", protocol.ReqGetProfile{UserName: "botOther", Token: "auth-token"}, protocol.RetTokenInvalid, rpc.OK, false},
+		{"User.NoRet", probeReq{UserName: "botAuth", Token: "bad-token"}, 0, rpc.Internal, false},
+	}
+	for _, test := range tests {
+		before := atomic.LoadInt32(&probe.called)
+		var resp protocol.RespGetProfile
+		var err error
+		if test.method == "User.NoRet" {
+			err = client.CallContext(ctx, test.method, test.req, &probeResp{})
+		} else {
+			err = client.CallContext(ctx, test.method, test.req, &resp)
+		}
+		called := atomic.LoadInt32(&probe.called) != before
+		if rpc.CodeOf(err) != test.code || resp.Ret != test.ret || called != test.called {
+			t.Errorf("authInterceptor didn't pass. method:%s, req:%+v, want ret:%d code:%v called:%t, got ret:%d err:%v called:%t",
+				test.method, test.req, test.ret, test.code, test.called, resp.Ret, err, called)
+		}
+	}
+}
+
+// TestUseInterceptors 测试耗CPU或数据库的方法都有配额.
+func TestUseInterceptors(t *testing.T) {
+	stats := useInterceptors(rpc.Server()).Stats()
+	for _, method := range []string{"User.SignUp", "User.Login"} {
+		if _, ok := stats[method]; !ok {
+			t.Errorf("useInterceptors didn't pass. method:%s has no quota, stats:%v", method, stats)
+		}
+	}
+}
+
+// TestAuthRequests 测试User服务中带Token字段的请求都实现了protocol.AuthRequest, 返回都实现了protocol.RetSetter.
+// 没有实现AuthRequest的请求不会被authInterceptor校验.
+func TestAuthRequests(t *testing.T) {
+	authRequest := reflect.TypeOf((*protocol.AuthRequest)(nil)).Elem()
+	retSetter := reflect.TypeOf((*protocol.RetSetter)(nil)).Elem()
+	userType := reflect.TypeOf(&User{})
+	for i := 0; i < userType.NumMethod(); i++ {
+		method := userType.Method(i)
+		if method.Type.NumIn() != 3 || method.Type.NumOut() != 2 {
+			continue
+		}
+		req, resp := method.Type.In(2), method.Type.Out(0)
+		if req.Kind() != reflect.Struct {
+			continue
+		}
+		if _, hasToken := req.FieldByName("Token"); !hasToken {
+			continue
+		}
+		if !req.Implements(authRequest) {
+			t.Errorf("User.%s didn't pass. %s has a Token field but doesn't implement protocol.AuthRequest", method.Name, req)
+		}
+		if !reflect.PtrTo(resp).Implements(retSetter) {
+			t.Errorf("User.%s didn't pass. *%s doesn't implement protocol.RetSetter", method.Name, resp)
+		}
+	}
+}
@@ -1,6 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"usermana/config"
 	"usermana/log"
 	"usermana/mysql"
@@ -16,16 +23,75 @@ func main() {
 		panic(err)
 	}
 	//init server.
-	server := rpc.Server()
-	//注册服务.
-	panicIfErr(server.Register("SignUp", SignUp, SignUpService))
-	panicIfErr(server.Register("Login", Login, LoginService))
-	panicIfErr(server.Register("GetProfile", GetProfile, GetProfileService))
-	panicIfErr(server.Register("UpdateProfilePic", UpdateProfilePic, UpdateProfilePicService))
-	panicIfErr(server.Register("UpdateNickName", UpdateNickName, UpdateNickNameService))
+	opts := []rpc.Option{rpc.MaxMessageSize(config.RPCMaxMessageSize)}
+	if config.RPCTLSCAFile != "" {
+		//只接受由CA签发了客户端证书的http server的连接.
+		certs, err := rpc.NewCertReloader(config.TCPServerCertFile, config.TCPServerKeyFile, config.RPCTLSCAFile)
+		panicIfErr(err)
+		certs.ReloadOnSignal(syscall.SIGHUP)
+		opts = append(opts, rpc.TLS(certs.ServerConfig()))
+	}
+	server := rpc.Server(opts...)
+	//注册拦截器和服务(User.SignUp, User.Login...).
+	quotas := useInterceptors(server)
+	panicIfErr(server.RegisterService(&User{}))
+	expvar.Publish("quota", expvar.Func(func() interface{} { return quotas.Stats() }))
+	if config.TCPServerAdminAddr != "" {
+		//管理接口使用单独的mux和地址, 不与调试接口共用DefaultServeMux.
+		panicIfErr(checkAdminConfig(config.TCPServerAdminAddr, config.TCPServerAdminSecret))
+		admin := &http.Server{Addr: config.TCPServerAdminAddr, Handler: newAdminMux(config.TCPServerAdminSecret)}
+		go func() {
+			if err := admin.ListenAndServe(); err != nil {
+				log.Errorf("tcp: admin server failed. err:%q", err)
+			}
+		}()
+	}
+	if config.TCPServerDebugAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(config.TCPServerDebugAddr, nil); err != nil {
+				log.Errorf("tcp: debug server failed. err:%q", err)
+			}
+		}()
+	}
+
+	//收到SIGINT/SIGTERM后等待正在处理的请求完成再退出, 滚动重启时不丢失请求.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		log.Infof("tcp: received %v, shutting down.", <-sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("tcp: shutdown failed. err:%q", err)
+		}
+	}()
 
 	//监听并且处理连接.
-	server.ListenAndServe(config.TCPServerAddr)
+	if err := server.ListenAndServe(config.TCPServerAddr); err != rpc.ErrServerClosed {
+		panic(err)
+	}
+	<-done
+	//所有请求处理完成后再关闭数据库和缓存的连接池.
+	if err := mysql.Close(); err != nil {
+		log.Errorf("tcp: mysql.Close failed. err:%q", err)
+	}
+	if err := redis.Close(); err != nil {
+		log.Errorf("tcp: redis.Close failed. err:%q", err)
+	}
+	log.Infof("tcp: shutdown done.")
+}
+
+// useInterceptors 为server注册日志、配额和鉴权拦截器, 返回配额用于查看使用情况. 超过配额的请求不再鉴权, 直接拒绝.
+func useInterceptors(server *rpc.RPCServer) *rpc.Quotas {
+	quotas := rpc.NewQuotas(map[string]rpc.Quota{
+		"User.SignUp": {MaxConcurrent: config.SignUpMaxConcurrent, MaxQueue: config.SignUpMaxQueue, Rate: config.SignUpRate},
+		"User.Login":  {MaxConcurrent: config.LoginMaxConcurrent, MaxQueue: config.LoginMaxQueue, Rate: config.LoginRate},
+	})
+	server.Use(logInterceptor, quotas.Interceptor, authInterceptor)
+	return quotas
 }
 
 // panicIfErr 错误包裹函数.
@@ -35,204 +101,268 @@ func panicIfErr(err error) {
 	}
 }
 
-// SignUp 注册接口.
-func SignUp(v interface{}) interface{} {
-	return SignUpService(*v.(*protocol.ReqSignUp))
-}
-
-// Login 登录接口.
-func Login(v interface{}) interface{} {
-	return LoginService(*v.(*protocol.ReqLogin))
-}
-
-// GetProfile 获取信息接口.
-func GetProfile(v interface{}) interface{} {
-	return GetProfileService(*v.(*protocol.ReqGetProfile))
-}
-
-// UpdateProfilePic 更新头像接口.
-func UpdateProfilePic(v interface{}) interface{} {
-	return UpdateProfilePicService(*v.(*protocol.ReqUpdateProfilePic))
-}
+// User 用户相关的rpc服务, 每个导出方法对应一个rpc接口. 需要登录的接口由authInterceptor统一校验token.
+type User struct{}
 
-// UpdateNickName 更新昵称接口
-func UpdateNickName(v interface{}) interface{} {
-	return UpdateNickNameService(*v.(*protocol.ReqUpdateNickName))
-}
-
-// SignUpService 注册接口的实际服务，同时用于在注册时向rpc传递参数类型.
-func SignUpService(req protocol.ReqSignUp) (resp protocol.RespSignUp) {
+// SignUp 注册接口.
+func (*User) SignUp(ctx context.Context, req protocol.ReqSignUp) (resp protocol.RespSignUp, err error) {
 	if req.UserName == "" || req.Password == "" {
 		resp.Ret = 1
-		return
+		return resp, nil
 	}
 	if req.NickName == "" {
 		req.NickName = req.UserName
 	}
 
-	if err := mysql.CreateAccount(req.UserName, req.Password); err != nil {
+	if err := mysql.CreateAccount(ctx, req.UserName, req.Password); err != nil {
 		resp.Ret = 2
 		log.Errorf("tcp.signUp: mysql.CreateAccount failed. usernam:%s, err:%q", req.UserName, err)
-		return
+		return resp, nil
 	}
-	if err := mysql.CreateProfile(req.UserName, req.NickName); err != nil {
+	if err := mysql.CreateProfile(ctx, req.UserName, req.NickName); err != nil {
 		resp.Ret = 2
 		log.Errorf("tcp.signUp: mysql.CreateProfile failed. usernam:%s, err:%q", req.UserName, err)
-		return
+		return resp, nil
 	}
 
 	resp.Ret = 0
-	return
+	return resp, nil
 }
 
-// LoginService 登录接口的实际服务，同时用于在注册时向rpc传递参数类型.
-func LoginService(req protocol.ReqLogin) (resp protocol.RespLogin) {
-	ok, err := mysql.LoginAuth(req.UserName, req.Password)
+// Login 登录接口.
+func (*User) Login(ctx context.Context, req protocol.ReqLogin) (resp protocol.RespLogin, err error) {
+	ok, err := mysql.LoginAuth(ctx, req.UserName, req.Password)
 	if err != nil {
 		resp.Ret = 2
 		log.Errorf("tcp.login: mysql.LoginAuth failed. usernam:%s, err:%q", req.UserName, err)
-		return
+		return resp, nil
 	}
 	//账号或密码不正确.
 	if !ok {
 		resp.Ret = 1
-		return
+		return resp, nil
 	}
-	token := utils.GetToken(req.UserName)
-	err = redis.SetToken(req.UserName, token, int64(config.TokenMaxExTime))
+	token, refreshToken, err := newTokens(req.Refresh)
 	if err != nil {
 		resp.Ret = 2
-		log.Errorf("tcp.login: redis.SetToken failed. usernam:%s, token:%s, err:%q", req.UserName, token, err)
-		return
+		log.Errorf("tcp.login: utils.NewToken failed. usernam:%s, err:%q", req.UserName, err)
+		return resp, nil
+	}
+	session := redis.Session{UserAgent: req.UserAgent, IP: req.IP}
+	err = redis.SetToken(ctx, req.UserName, token, refreshToken, session, tokenLifetime)
+	if err != nil {
+		resp.Ret = 2
+		log.Errorf("tcp.login: redis.SetToken failed. usernam:%s, err:%q", req.UserName, err)
+		return resp, nil
 	}
 	resp.Ret = 0
 	resp.Token = token
+	resp.RefreshToken = refreshToken
 	log.Infof("tcp.login: login done. username:%s", req.UserName)
-	return
+	return resp, nil
 }
 
-// GetProfileService 获取信息接口的实际服务，同时用于在注册时向rpc传递参数类型.
-func GetProfileService(req protocol.ReqGetProfile) (resp protocol.RespGetProfile) {
-	// 校验token
-	ok, err := checkToken(req.UserName, req.Token)
+// RefreshToken 刷新token接口, 用refresh token换取新的token和refresh token, 旧的都失效.
+func (*User) RefreshToken(ctx context.Context, req protocol.ReqRefreshToken) (resp protocol.RespRefreshToken, err error) {
+	if req.UserName == "" || req.RefreshToken == "" {
+		resp.Ret = 1
+		return resp, nil
+	}
+	token, refreshToken, err := newTokens(true)
 	if err != nil {
-		resp.Ret = 3
-		log.Errorf("tcp.getProfile: checkToken failed. usernam:%s, token:%s, err:%q", req.UserName, req.Token, err)
-		return
+		resp.Ret = 2
+		log.Errorf("tcp.refreshToken: utils.NewToken failed. usernam:%s, err:%q", req.UserName, err)
+		return resp, nil
+	}
+	ok, err := redis.RefreshToken(ctx, req.UserName, req.RefreshToken, token, refreshToken)
+	if err != nil {
+		resp.Ret = 2
+		log.Errorf("tcp.refreshToken: redis.RefreshToken failed. usernam:%s, err:%q", req.UserName, err)
+		return resp, nil
 	}
 	if !ok {
 		resp.Ret = 1
-		return
+		return resp, nil
 	}
+	resp.Ret = 0
+	resp.Token, resp.RefreshToken = token, refreshToken
+	log.Infof("tcp.refreshToken done. username:%s", req.UserName)
+	return resp, nil
+}
 
+// GetProfile 获取信息接口.
+func (*User) GetProfile(ctx context.Context, req protocol.ReqGetProfile) (resp protocol.RespGetProfile, err error) {
 	// 先尝试从redis取数据.
-	nickName, picName, hasData, err := redis.GetProfile(req.UserName)
+	nickName, picName, hasData, err := redis.GetProfile(ctx, req.UserName)
 	if err != nil {
 		resp.Ret = 3
 		log.Errorf("tcp.getProfile: redis.GetProfile failed. username:%s, err:%q", req.UserName, err)
-		return
+		return resp, nil
 	}
 	if hasData {
 		log.Infof("redis tcp.getProfile done. username:%s", req.UserName)
-		return protocol.RespGetProfile{Ret: 0, UserName: req.UserName, NickName: nickName, PicName: picName}
+		return protocol.RespGetProfile{Ret: 0, UserName: req.UserName, NickName: nickName, PicName: picName}, nil
 	}
 
 	//redis没有数据，从mysql里取.
-	nickName, picName, hasData, err = mysql.GetProfile(req.UserName)
+	nickName, picName, hasData, err = mysql.GetProfile(ctx, req.UserName)
 	if err != nil {
 		resp.Ret = 3
 		log.Errorf("mysql tcp.getProfile: mysql.GetProfile failed. username:%s, err:%q", req.UserName, err)
-		return
+		return resp, nil
 	}
 	if hasData {
 		// 向redis插入数据.
-		redis.SetNickNameAndPicName(req.UserName, nickName, picName)
+		redis.SetNickNameAndPicName(ctx, req.UserName, nickName, picName)
 	} else {
 		resp.Ret = 2
 		log.Errorf("tcp.getProfile: mysql.GetProfile can't find username. username:%s", req.UserName)
-		return
+		return resp, nil
 	}
 	log.Infof("tcp.getProfile done. username:%s", req.UserName)
-	return protocol.RespGetProfile{Ret: 0, UserName: req.UserName, NickName: nickName, PicName: picName}
+	return protocol.RespGetProfile{Ret: 0, UserName: req.UserName, NickName: nickName, PicName: picName}, nil
 
 }
 
-// UpdateProfilePicService 更新头像接口的实际服务(picName/FileName)，同时用于在注册时向rpc传递参数类型.
-func UpdateProfilePicService(req protocol.ReqUpdateProfilePic) (resp protocol.RespUpdateProfilePic) {
-	// 校验token.
-	ok, err := checkToken(req.UserName, req.Token)
+// UpdateProfilePic 更新头像接口.
+func (*User) UpdateProfilePic(ctx context.Context, req protocol.ReqUpdateProfilePic) (resp protocol.RespUpdateProfilePic, err error) {
+	// 使redis对应的数据失效（由于数据将会被修改）.
+	if err := redis.InvaildCache(ctx, req.UserName); err != nil {
+		resp.Ret = 3
+		log.Errorf("tcp.updateProfilePic: redis.InvaildCache failed. username:%s, err:%q", req.UserName, err)
+		return resp, nil
+	}
+	// 写入数据库.
+	ok, err := mysql.UpdateProfilePic(ctx, req.UserName, req.FileName)
 	if err != nil {
 		resp.Ret = 3
-		log.Errorf("tcp.updateProfilePic: checkToken failed. username:%s, token:%s, err:%q", req.UserName, req.Token, err)
-		return
+		log.Errorf("tcp.updateProfilePic: mysql.UpdateProfilePic failed. username:%s, filename:%s, err:%q", req.UserName, req.FileName, err)
+		return resp, nil
 	}
 	if !ok {
-		resp.Ret = 1
-		return
+		resp.Ret = 2
+		return resp, nil
 	}
+	resp.Ret = 0
+	log.Infof("tcp.updateProfilePic done. username:%s, filename:%s", req.UserName, req.FileName)
+	return resp, nil
+}
 
+// UpdateNickName 更新昵称接口.
+func (*User) UpdateNickName(ctx context.Context, req protocol.ReqUpdateNickName) (resp protocol.RespUpdateNickName, err error) {
 	// 使redis对应的数据失效（由于数据将会被修改）.
-	if err := redis.InvaildCache(req.UserName); err != nil {
+	if err := redis.InvaildCache(ctx, req.UserName); err != nil {
 		resp.Ret = 3
-		log.Errorf("tcp.updateProfilePic: redis.InvaildCache failed. username:%s, err:%q", req.UserName, err)
-		return
+		log.Errorf("tcp.updateNickName: redis.InvaildCache failed. username:%s, err:%q", req.UserName, err)
+		return resp, nil
 	}
 	// 写入数据库.
-	ok, err = mysql.UpdateProfilePic(req.UserName, req.FileName)
+	ok, err := mysql.UpdateNikcName(ctx, req.UserName, req.NickName)
 	if err != nil {
 		resp.Ret = 3
-		log.Errorf("tcp.updateProfilePic: mysql.UpdateProfilePic failed. username:%s, filename:%s, err:%q", req.UserName, req.FileName, err)
-		return
+		log.Errorf("tcp.updateNickName: mysql.UpdateNikcName failed. username:%s, nickname:%s, err:%q", req.UserName, req.NickName, err)
+		return resp, nil
 	}
 	if !ok {
 		resp.Ret = 2
-		return
+		return resp, nil
 	}
 	resp.Ret = 0
-	log.Infof("tcp.updateProfilePic done. username:%s, filename:%s", req.UserName, req.FileName)
-	return
+	log.Infof("tcp.updateNickName done. username:%s, nickname:%s", req.UserName, req.NickName)
+	return resp, nil
+}
+
+// Logout 退出登录接口, 删除token对应的会话.
+func (*User) Logout(ctx context.Context, req protocol.ReqLogout) (resp protocol.RespLogout, err error) {
+	if _, err := redis.DeleteSession(ctx, req.UserName, redis.SessionID(req.Token)); err != nil {
+		resp.Ret = 3
+		log.Errorf("tcp.logout: redis.DeleteSession failed. username:%s, err:%q", req.UserName, err)
+		return resp, nil
+	}
+	resp.Ret = 0
+	log.Infof("tcp.logout done. username:%s", req.UserName)
+	return resp, nil
 }
 
-// UpdateNickNameService 更新昵称接口的实际服务(NickName)，同时用于在注册时向rpc传递参数类型.
-func UpdateNickNameService(req protocol.ReqUpdateNickName) (resp protocol.RespUpdateNickName) {
-	// 校验token.
-	ok, err := checkToken(req.UserName, req.Token)
+// ListSessions 获取会话列表接口.
+func (*User) ListSessions(ctx context.Context, req protocol.ReqListSessions) (resp protocol.RespListSessions, err error) {
+	sessions, err := redis.ListSessions(ctx, req.UserName)
 	if err != nil {
 		resp.Ret = 3
-		log.Errorf("tcp.updateNickName: checkToken failed. username:%s, token:%s, err:%q", req.UserName, req.Token, err)
-		return
+		log.Errorf("tcp.listSessions: redis.ListSessions failed. username:%s, err:%q", req.UserName, err)
+		return resp, nil
 	}
-	if !ok {
-		resp.Ret = 1
-		return
+	current := redis.SessionID(req.Token)
+	for _, s := range sessions {
+		resp.Sessions = append(resp.Sessions, protocol.Session{
+			ID:        s.ID,
+			CreatedAt: s.CreatedAt,
+			LastSeen:  s.LastSeen,
+			UserAgent: s.UserAgent,
+			IP:        s.IP,
+			Current:   s.ID == current,
+		})
 	}
-	// 使redis对应的数据失效（由于数据将会被修改）.
-	if err := redis.InvaildCache(req.UserName); err != nil {
-		resp.Ret = 3
-		log.Errorf("tcp.updateNickName: redis.InvaildCache failed. username:%s, err:%q", req.UserName, err)
-		return
+	resp.Ret = 0
+	return resp, nil
+}
+
+// RevokeSession 退出指定会话接口, 可以退出当前会话.
+func (*User) RevokeSession(ctx context.Context, req protocol.ReqRevokeSession) (resp protocol.RespRevokeSession, err error) {
+	if !redis.ValidSessionID(req.SessionID) {
+		resp.Ret = 2
+		return resp, nil
 	}
-	// 写入数据库.
-	ok, err = mysql.UpdateNikcName(req.UserName, req.NickName)
+	ok, err := redis.DeleteSession(ctx, req.UserName, req.SessionID)
 	if err != nil {
 		resp.Ret = 3
-		log.Errorf("tcp.updateNickName: mysql.UpdateNikcName failed. username:%s, nickname:%s, err:%q", req.UserName, req.NickName, err)
-		return
+		log.Errorf("tcp.revokeSession: redis.DeleteSession failed. username:%s, session:%s, err:%q", req.UserName, req.SessionID, err)
+		return resp, nil
 	}
 	if !ok {
 		resp.Ret = 2
-		return
+		return resp, nil
 	}
 	resp.Ret = 0
-	log.Infof("tcp.updateNickName done. username:%s, nickname:%s", req.UserName, req.NickName)
-	return
+	log.Infof("tcp.revokeSession done. username:%s, session:%s", req.UserName, req.SessionID)
+	return resp, nil
+}
+
+// RevokeOtherSessions 退出当前会话以外的所有会话接口.
+func (*User) RevokeOtherSessions(ctx context.Context, req protocol.ReqRevokeOtherSessions) (resp protocol.RespRevokeOtherSessions, err error) {
+	n, err := redis.DeleteOtherSessions(ctx, req.UserName, redis.SessionID(req.Token))
+	if err != nil {
+		resp.Ret = 3
+		log.Errorf("tcp.revokeOtherSessions: redis.DeleteOtherSessions failed. username:%s, err:%q", req.UserName, err)
+		return resp, nil
+	}
+	resp.Ret = 0
+	resp.Revoked = n
+	log.Infof("tcp.revokeOtherSessions done. username:%s, revoked:%d", req.UserName, n)
+	return resp, nil
+}
+
+// tokenLifetime token和refresh token的存活时间.
+var tokenLifetime = redis.Lifetime{
+	Idle:    int64(config.TokenMaxExTime),
+	Max:     int64(config.TokenMaxLifetime),
+	Refresh: int64(config.RefreshTokenExTime),
+}
+
+// newTokens 生成token, refresh为true时同时生成refresh token, 否则refresh token为空.
+func newTokens(refresh bool) (token string, refreshToken string, err error) {
+	if token, err = utils.NewToken(); err != nil || !refresh {
+		return token, "", err
+	}
+	refreshToken, err = utils.NewToken()
+	return token, refreshToken, err
 }
 
-//checkToken  检查Token
-func checkToken(userName string, token string) (bool, error) {
-	// 压测token
-	if token == "test" {
+//checkToken  检查Token, 校验成功时延长token的失效时间.
+func checkToken(ctx context.Context, userName string, token string) (bool, error) {
+	// 压测token, 只在压测环境中配置.
+	if config.BenchmarkToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(config.BenchmarkToken)) == 1 {
 		return true, nil
 	}
-	return redis.CheckToken(userName, token)
+	return redis.CheckToken(ctx, userName, token)
 }
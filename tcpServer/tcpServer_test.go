@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"usermana/protocol"
 )
 
 var token string
 
-// TestSignUpService 测试用户注册函数SignUpService.
-func TestSignUpService(t *testing.T) {
+// user 被测试的rpc服务.
+var user = &User{}
+
+// TestSignUp 测试用户注册方法User.SignUp.
+func TestSignUp(t *testing.T) {
 	var tests = []struct {
 		req protocol.ReqSignUp
 		ret int
@@ -20,15 +28,15 @@ func TestSignUpService(t *testing.T) {
 		}, 0},
 	}
 	for _, test := range tests {
-		resp := SignUpService(test.req)
-		if resp.Ret != test.ret {
-			t.Errorf("SignUpService didn't pass. username:%s, password:%s, nickname:%s, ret:%d", test.req.UserName, test.req.Password, test.req.NickName, test.ret)
+		resp, err := user.SignUp(context.Background(), test.req)
+		if err != nil || resp.Ret != test.ret {
+			t.Errorf("User.SignUp didn't pass. username:%s, password:%s, nickname:%s, ret:%d", test.req.UserName, test.req.Password, test.req.NickName, test.ret)
 		}
 	}
 }
 
-//TestLoginService 测试用的登陆函数LoginService.
-func TestLoginService(t *testing.T) {
+//TestLogin 测试用的登陆方法User.Login.
+func TestLogin(t *testing.T) {
 	var tests = []struct {
 		req protocol.ReqLogin
 		ret int
@@ -39,17 +47,17 @@ func TestLoginService(t *testing.T) {
 		}, 0},
 	}
 	for _, test := range tests {
-		resp := LoginService(test.req)
-		if resp.Ret != test.ret {
-			t.Errorf("LoginService didn't pass. username:%s, password:%s, ret:%d", test.req.UserName, test.req.Password, test.ret)
+		resp, err := user.Login(context.Background(), test.req)
+		if err != nil || resp.Ret != test.ret {
+			t.Errorf("User.Login didn't pass. username:%s, password:%s, ret:%d", test.req.UserName, test.req.Password, test.ret)
 		} else {
 			token = resp.Token
 		}
 	}
 }
 
-// TestGetProfileService 测试获取用户信息函数TestGetProfileService.
-func TestGetProfileService(t *testing.T) {
+// TestGetProfile 测试获取用户信息方法User.GetProfile.
+func TestGetProfile(t *testing.T) {
 	var tests = []struct {
 		req protocol.ReqGetProfile
 		ret int
@@ -60,15 +68,15 @@ func TestGetProfileService(t *testing.T) {
 		}, 0},
 	}
 	for _, test := range tests {
-		resp := GetProfileService(test.req)
-		if resp.Ret != test.ret {
-			t.Errorf("GetProfileService didn't pass. username:%s, ret:%d", test.req.UserName, test.ret)
+		resp, err := user.GetProfile(context.Background(), test.req)
+		if err != nil || resp.Ret != test.ret {
+			t.Errorf("User.GetProfile didn't pass. username:%s, ret:%d", test.req.UserName, test.ret)
 		}
 	}
 }
 
-// TestUpdateProfilePicService 测试更新用户信息函数UpdateProfilePicService.
-func TestUpdateProfilePicService(t *testing.T) {
+// TestUpdateProfilePic 测试更新用户信息方法User.UpdateProfilePic.
+func TestUpdateProfilePic(t *testing.T) {
 	var tests = []struct {
 		req protocol.ReqUpdateProfilePic
 		ret int
@@ -80,15 +88,15 @@ func TestUpdateProfilePicService(t *testing.T) {
 		}, 0},
 	}
 	for _, test := range tests {
-		resp := UpdateProfilePicService(test.req)
-		if resp.Ret != test.ret {
-			t.Errorf("UpdateProfilePicService didn't pass. username:%s, filename:%s, ret:%d", test.req.UserName, test.req.FileName, test.ret)
+		resp, err := user.UpdateProfilePic(context.Background(), test.req)
+		if err != nil || resp.Ret != test.ret {
+			t.Errorf("User.UpdateProfilePic didn't pass. username:%s, filename:%s, ret:%d", test.req.UserName, test.req.FileName, test.ret)
 		}
 	}
 }
 
-// TestUpdateNickNameService 测试更新用户昵称函数UpdateNickNameService.
-func TestUpdateNickNameService(t *testing.T) {
+// TestUpdateNickName 测试更新用户昵称方法User.UpdateNickName.
+func TestUpdateNickName(t *testing.T) {
 	var tests = []struct {
 		req protocol.ReqUpdateNickName
 		ret int
@@ -100,9 +108,184 @@ func TestUpdateNickNameService(t *testing.T) {
 		}, 0},
 	}
 	for _, test := range tests {
-		resp := UpdateNickNameService(test.req)
-		if resp.Ret != test.ret {
-			t.Errorf("UpdateNickNameService didn't pass. username:%s, ret:%d", test.req.UserName, test.ret)
+		resp, err := user.UpdateNickName(context.Background(), test.req)
+		if err != nil || resp.Ret != test.ret {
+			t.Errorf("User.UpdateNickName didn't pass. username:%s, ret:%d", test.req.UserName, test.ret)
+		}
+	}
+}
+
+// TestSessions 测试会话相关的方法User.ListSessions、User.RevokeOtherSessions和User.RevokeSession.
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	var tokens []string
+	for _, ua := range []string{"device1", "device2"} {
+		resp, err := user.Login(ctx, protocol.ReqLogin{UserName: "botSignUp1", Password: "123", UserAgent: ua, IP: "127.0.0.1"})
+		if err != nil || resp.Ret != 0 {
+			t.Fatalf("User.Login didn't pass. useragent:%s, ret:%d, err:%q", ua, resp.Ret, err)
+		}
+		tokens = append(tokens, resp.Token)
+	}
+
+	list, err := user.ListSessions(ctx, protocol.ReqListSessions{UserName: "botSignUp1", Token: tokens[1]})
+	if err != nil || list.Ret != 0 || len(list.Sessions) < 2 {
+		t.Fatalf("User.ListSessions didn't pass. ret:%d, sessions:%v, err:%q", list.Ret, list.Sessions, err)
+	}
+	//两次登录可能在同一秒内, 不依赖排序, 按Current找到当前会话.
+	var current protocol.Session
+	for _, s := range list.Sessions {
+		if s.Current {
+			current = s
+		}
+	}
+	if current.UserAgent != "device2" {
+		t.Fatalf("User.ListSessions didn't pass. want current session device2, sessions:%v", list.Sessions)
+	}
+	others, err := user.RevokeOtherSessions(ctx, protocol.ReqRevokeOtherSessions{UserName: "botSignUp1", Token: tokens[1]})
+	if err != nil || others.Ret != 0 || others.Revoked != len(list.Sessions)-1 {
+		t.Errorf("User.RevokeOtherSessions didn't pass. ret:%d, revoked:%d, err:%q", others.Ret, others.Revoked, err)
+	}
+	if ok, err := checkToken(ctx, "botSignUp1", tokens[0]); err != nil || ok {
+		t.Errorf("checkToken didn't pass. revoked token is still valid, err:%q", err)
+	}
+
+	var tests = []struct {
+		req protocol.ReqRevokeSession
+		ret int
+	}{
+		{protocol.ReqRevokeSession{UserName: "botSignUp1", Token: tokens[1], SessionID: current.ID}, 0},
+		{protocol.ReqRevokeSession{UserName: "botSignUp1", Token: tokens[1], SessionID: current.ID}, 2},
+		{protocol.ReqRevokeSession{UserName: "botSignUp1", Token: tokens[1], SessionID: "x_" + current.ID}, 2},
+	}
+	for _, test := range tests {
+		resp, err := user.RevokeSession(ctx, test.req)
+		if err != nil || resp.Ret != test.ret {
+			t.Errorf("User.RevokeSession didn't pass. session:%s, ret:%d", test.req.SessionID, test.ret)
+		}
+	}
+}
+
+// TestLogout 测试退出登录方法User.Logout, 退出后token失效, 其他会话不受影响.
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		resp, err := user.Login(ctx, protocol.ReqLogin{UserName: "botSignUp1", Password: "123"})
+		if err != nil || resp.Ret != 0 {
+			t.Fatalf("User.Login didn't pass. ret:%d, err:%q", resp.Ret, err)
+		}
+		tokens = append(tokens, resp.Token)
+	}
+	if resp, err := user.Logout(ctx, protocol.ReqLogout{UserName: "botSignUp1", Token: tokens[0]}); err != nil || resp.Ret != 0 {
+		t.Errorf("User.Logout didn't pass. ret:%d, err:%q", resp.Ret, err)
+	}
+	var tests = []struct {
+		token string
+		ok    bool
+	}{
+		{tokens[0], false},
+		{tokens[1], true},
+	}
+	for _, test := range tests {
+		if ok, err := checkToken(ctx, "botSignUp1", test.token); err != nil || ok != test.ok {
+			t.Errorf("checkToken didn't pass. token:%s, ok:%t, err:%q", test.token, test.ok, err)
+		}
+	}
+}
+
+// TestRevokeSessionsHandler 测试管理接口/admin/revokeSessions, 没有密钥时拒绝请求, 调用后用户所有的token立即失效.
+func TestRevokeSessionsHandler(t *testing.T) {
+	ctx := context.Background()
+	resp, err := user.Login(ctx, protocol.ReqLogin{UserName: "botSignUp1", Password: "123"})
+	if err != nil || resp.Ret != 0 {
+		t.Fatalf("User.Login didn't pass. ret:%d, err:%q", resp.Ret, err)
+	}
+
+	mux := newAdminMux("s3cret")
+	var tests = []struct {
+		method string
+		secret string
+		form   url.Values
+		code   int
+		valid  bool // 请求后token是否仍然有效.
+	}{
+		{"POST", "", url.Values{"username": {"botSignUp1"}}, http.StatusForbidden, true},
+		{"POST", "s3cre", url.Values{"username": {"botSignUp1"}}, http.StatusForbidden, true},
+		{"GET", "s3cret", url.Values{"username": {"botSignUp1"}}, http.StatusMethodNotAllowed, true},
+		{"POST", "s3cret", url.Values{}, http.StatusBadRequest, true},
+		{"POST", "s3cret", url.Values{"username": {"botSignUp1"}}, http.StatusOK, false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/admin/revokeSessions", strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if test.secret != "" {
+			req.Header.Set("X-Admin-Secret", test.secret)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Errorf("revokeSessionsHandler didn't pass. method:%s, form:%v, want:%d, code:%d", test.method, test.form, test.code, rec.Code)
 		}
+		if ok, err := checkToken(ctx, "botSignUp1", resp.Token); err != nil || ok != test.valid {
+			t.Errorf("checkToken didn't pass. method:%s, form:%v, want:%t, ok:%t, err:%q", test.method, test.form, test.valid, ok, err)
+		}
+	}
+}
+
+// TestCheckAdminConfig 测试管理接口的配置检查, 只允许带密钥的本机地址.
+func TestCheckAdminConfig(t *testing.T) {
+	var tests = []struct {
+		addr, secret string
+		ok           bool
+	}{
+		{"localhost:6195", "s3cret", true},
+		{"127.0.0.1:6195", "s3cret", true},
+		{"[::1]:6195", "s3cret", true},
+		{"localhost:6195", "", false},
+		{":6195", "s3cret", false},
+		{"0.0.0.0:6195", "s3cret", false},
+		{"10.0.0.1:6195", "s3cret", false},
+		{"example.com:6195", "s3cret", false},
+		{"localhost", "s3cret", false},
+	}
+	for _, test := range tests {
+		if err := checkAdminConfig(test.addr, test.secret); (err == nil) != test.ok {
+			t.Errorf("checkAdminConfig didn't pass. addr:%s, secret:%s, want:%t, err:%v", test.addr, test.secret, test.ok, err)
+		}
+	}
+}
+
+// TestRefreshToken 测试刷新token方法User.RefreshToken.
+func TestRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	login, err := user.Login(ctx, protocol.ReqLogin{UserName: "botSignUp1", Password: "123", Refresh: true})
+	if err != nil || login.Ret != 0 || login.RefreshToken == "" {
+		t.Fatalf("User.Login didn't pass. ret:%d, refresh_token:%s, err:%q", login.Ret, login.RefreshToken, err)
+	}
+
+	var tests = []struct {
+		req protocol.ReqRefreshToken
+		ret int
+	}{
+		{protocol.ReqRefreshToken{UserName: "botSignUp1", RefreshToken: login.RefreshToken}, 0},
+		{protocol.ReqRefreshToken{UserName: "botSignUp1", RefreshToken: login.RefreshToken}, 1},
+		{protocol.ReqRefreshToken{UserName: "botSignUp1", RefreshToken: login.Token}, 1},
+		{protocol.ReqRefreshToken{UserName: "botSignUp1"}, 1},
+	}
+	var token string
+	for _, test := range tests {
+		resp, err := user.RefreshToken(ctx, test.req)
+		if err != nil || resp.Ret != test.ret {
+			t.Errorf("User.RefreshToken didn't pass. refresh_token:%s, ret:%d", test.req.RefreshToken, test.ret)
+		}
+		if resp.Ret == 0 {
+			token = resp.Token
+		}
+	}
+	if ok, err := checkToken(ctx, "botSignUp1", login.Token); err != nil || ok {
+		t.Errorf("checkToken didn't pass. token is still valid after refresh, err:%q", err)
+	}
+	if ok, err := checkToken(ctx, "botSignUp1", token); err != nil || !ok {
+		t.Errorf("checkToken didn't pass. refreshed token is invalid, err:%q", err)
 	}
 }
@@ -3,7 +3,9 @@ package utils
 import (
 	"EntryTask/utils"
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"path"
 	"strconv"
@@ -17,11 +19,16 @@ func Sha256(passwd string) string {
 	return hex.EncodeToString(rh.Sum(nil))
 }
 
-//GetToken 将src生成Token字符串，并将其返回.
-func GetToken(src string) string {
-	h := md5.New()
-	h.Write([]byte(src + strconv.FormatInt(time.Now().Unix(), 10)))
-	return hex.EncodeToString(h.Sum(nil))
+// TokenSize token的随机字节数.
+const TokenSize = 32
+
+// NewToken 生成一个由crypto/rand产生的随机token(base64编码, 可以直接放在cookie中).
+func NewToken() (string, error) {
+	b := make([]byte, TokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
 // GetFileName 为上传的文件生成一个文件名.